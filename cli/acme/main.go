@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sagernet/sing-tools/extensions/acme"
+	_ "github.com/sagernet/sing-tools/extensions/log"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type Flags struct {
+	LogLevel string `json:"log_level"`
+	acme.Settings
+}
+
+var (
+	configPath    string
+	revokeReason  uint
+	rotateKeyType string
+)
+
+func main() {
+	command := &cobra.Command{
+		Use:   "acme [-c config.json]",
+		Short: "manage certificates of the acme store",
+	}
+	command.PersistentFlags().StringVarP(&configPath, "config", "c", "config.json", "set config path")
+	command.AddCommand(&cobra.Command{
+		Use:   "obtain domain...",
+		Short: "obtain a certificate for domains, unless a valid one exists",
+		Args:  cobra.MinimumNArgs(1),
+		Run:   obtain,
+	})
+	command.AddCommand(&cobra.Command{
+		Use:   "renew domain...",
+		Short: "renew the certificate for domains",
+		Args:  cobra.MinimumNArgs(1),
+		Run:   renew,
+	})
+	revokeCommand := &cobra.Command{
+		Use:   "revoke domain...",
+		Short: "revoke and delete the certificate for domains",
+		Args:  cobra.MinimumNArgs(1),
+		Run:   revoke,
+	}
+	revokeCommand.Flags().UintVar(&revokeReason, "reason", 0, "set RFC 5280 revocation reason code, e.g. 1 for key compromise, 4 for superseded")
+	command.AddCommand(revokeCommand)
+	command.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "list certificates",
+		Args:  cobra.NoArgs,
+		Run:   list,
+	})
+	command.AddCommand(&cobra.Command{
+		Use:   "delete domain...",
+		Short: "delete the certificate and private key for domains",
+		Args:  cobra.MinimumNArgs(1),
+		Run:   deleteCertificate,
+	})
+	accountCommand := &cobra.Command{
+		Use:   "account",
+		Short: "manage the acme account",
+	}
+	accountCommand.AddCommand(&cobra.Command{
+		Use:   "show",
+		Short: "show the acme account",
+		Args:  cobra.NoArgs,
+		Run:   showAccount,
+	})
+	rotateCommand := &cobra.Command{
+		Use:   "rotate",
+		Short: "replace the acme account key",
+		Args:  cobra.NoArgs,
+		Run:   rotateAccount,
+	}
+	rotateCommand.Flags().StringVar(&rotateKeyType, "key-type", "", "set new key type [possible values: ec256, ec384, rsa2048, rsa4096] (default account_key_type)")
+	accountCommand.AddCommand(rotateCommand)
+	command.AddCommand(accountCommand)
+	if err := command.Execute(); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func newManager() (*acme.CertificateManager, *Flags) {
+	configFile, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		logrus.Fatal(E.Cause(err, "read config file"))
+	}
+	f := new(Flags)
+	err = json.Unmarshal(configFile, f)
+	if err != nil {
+		logrus.Fatal(E.Cause(err, "parse config file"))
+	}
+	if f.LogLevel != "" {
+		level, err := logrus.ParseLevel(f.LogLevel)
+		if err != nil {
+			logrus.Fatal("unknown log level ", f.LogLevel)
+		}
+		logrus.SetLevel(level)
+	}
+	manager, err := acme.CreateCertificateManager(&f.Settings)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	return manager, f
+}
+
+func obtain(cmd *cobra.Command, args []string) {
+	manager, _ := newManager()
+	keyPair, err := manager.GetKeyPairForDomains(args)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Info("certificate for ", strings.Join(keyPair.Leaf.DNSNames, ", "), " expires at ", keyPair.Leaf.NotAfter.Format(time.RFC3339))
+}
+
+func renew(cmd *cobra.Command, args []string) {
+	manager, _ := newManager()
+	keyPairs, err := manager.RenewForDomains(args)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Info("certificate for ", strings.Join(keyPairs[0].Leaf.DNSNames, ", "), " renewed, expires at ", keyPairs[0].Leaf.NotAfter.Format(time.RFC3339))
+}
+
+func revoke(cmd *cobra.Command, args []string) {
+	manager, _ := newManager()
+	err := manager.RevokeForDomains(args, revokeReason)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Info("certificate for ", strings.Join(args, ", "), " revoked")
+}
+
+func list(cmd *cobra.Command, args []string) {
+	manager, _ := newManager()
+	certificates, err := manager.ListCertificates()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAME\tDOMAINS\tKEY\tISSUER\tEXPIRES\tDAYS LEFT")
+	for _, it := range certificates {
+		daysLeft := int(time.Until(it.NotAfter).Hours() / 24)
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%d\n", it.Name, strings.Join(it.Domains, ","), it.KeyType, it.Issuer, it.NotAfter.Format(time.RFC3339), daysLeft)
+	}
+	writer.Flush()
+}
+
+func deleteCertificate(cmd *cobra.Command, args []string) {
+	manager, _ := newManager()
+	err := manager.DeleteForDomains(args)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Info("certificate for ", strings.Join(args, ", "), " deleted")
+}
+
+func showAccount(cmd *cobra.Command, args []string) {
+	manager, _ := newManager()
+	account, err := manager.Account()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "Directory:\t"+account.Directory)
+	fmt.Fprintln(writer, "URI:\t"+account.URI)
+	fmt.Fprintln(writer, "Email:\t"+account.Email)
+	fmt.Fprintln(writer, "Status:\t"+account.Status)
+	fmt.Fprintln(writer, "Key type:\t"+string(account.KeyType))
+	fmt.Fprintln(writer, "Thumbprint:\t"+account.Thumbprint)
+	writer.Flush()
+}
+
+func rotateAccount(cmd *cobra.Command, args []string) {
+	manager, f := newManager()
+	keyTypeName := rotateKeyType
+	if keyTypeName == "" {
+		keyTypeName = f.AccountKeyType
+	}
+	keyType, err := acme.ParseKeyType(keyTypeName)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	err = manager.RotateAccountKey(keyType)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Info("account key replaced")
+}
@@ -0,0 +1,182 @@
+//go:build linux
+
+package main
+
+import (
+	"net/netip"
+	"path"
+	"sort"
+	"syscall"
+
+	"github.com/sagernet/sing/common"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/vishvananda/netlink"
+)
+
+type localAddr struct {
+	netip.Addr
+	iface    string
+	flags    int
+	external bool
+}
+
+// temporary reports whether the address is an IPv6 privacy extension address.
+func (a localAddr) temporary() bool {
+	return a.Is6() && a.flags&syscall.IFA_F_TEMPORARY != 0
+}
+
+// deprecated reports whether the preferred lifetime of the address has expired.
+func (a localAddr) deprecated() bool {
+	return a.flags&syscall.IFA_F_DEPRECATED != 0
+}
+
+func isPublicAddr(addr netip.Addr) bool {
+	return addr.IsGlobalUnicast() && !addr.IsPrivate()
+}
+
+func localPublicAddrs() ([]localAddr, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, E.Cause(err, "list links")
+	}
+	names := make(map[int]string)
+	for _, link := range links {
+		names[link.Attrs().Index] = link.Attrs().Name
+	}
+	addrs, err := netlink.AddrList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, E.Cause(err, "list addresses")
+	}
+	var publicAddrs []localAddr
+	for _, it := range addrs {
+		addr, ok := netip.AddrFromSlice(it.IP)
+		if !ok {
+			continue
+		}
+		addr = addr.Unmap()
+		if !isPublicAddr(addr) {
+			continue
+		}
+		publicAddrs = append(publicAddrs, localAddr{Addr: addr, iface: names[it.LinkIndex], flags: it.Flags})
+	}
+	return publicAddrs, nil
+}
+
+type FilterOptions struct {
+	// IncludeInterfaces and ExcludeInterfaces are glob patterns of interface names,
+	// not applied to external addresses.
+	IncludeInterfaces []string `json:"include_interfaces"`
+	ExcludeInterfaces []string `json:"exclude_interfaces"`
+	// Allow and Deny are lists of CIDR prefixes.
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+	// SkipTemporary skips IPv6 privacy extension addresses.
+	SkipTemporary  bool `json:"skip_temporary"`
+	SkipDeprecated bool `json:"skip_deprecated"`
+	// Single publishes only the best address of each type instead of all of them.
+	Single bool `json:"single"`
+}
+
+type addrFilter struct {
+	include        []string
+	exclude        []string
+	allow          []netip.Prefix
+	deny           []netip.Prefix
+	skipTemporary  bool
+	skipDeprecated bool
+	single         bool
+}
+
+func newAddrFilter(options *FilterOptions) (*addrFilter, error) {
+	filter := new(addrFilter)
+	if options == nil {
+		return filter, nil
+	}
+	for _, pattern := range append(append([]string{}, options.IncludeInterfaces...), options.ExcludeInterfaces...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, E.Cause(err, "bad interface pattern ", pattern)
+		}
+	}
+	filter.include = options.IncludeInterfaces
+	filter.exclude = options.ExcludeInterfaces
+	var err error
+	filter.allow, err = parsePrefixes(options.Allow)
+	if err != nil {
+		return nil, err
+	}
+	filter.deny, err = parsePrefixes(options.Deny)
+	if err != nil {
+		return nil, err
+	}
+	filter.skipTemporary = options.SkipTemporary
+	filter.skipDeprecated = options.SkipDeprecated
+	filter.single = options.Single
+	return filter, nil
+}
+
+func parsePrefixes(prefixes []string) ([]netip.Prefix, error) {
+	var parsed []netip.Prefix
+	for _, it := range prefixes {
+		prefix, err := netip.ParsePrefix(it)
+		if err != nil {
+			return nil, E.Cause(err, "parse prefix ", it)
+		}
+		parsed = append(parsed, prefix.Masked())
+	}
+	return parsed, nil
+}
+
+func matchInterface(patterns []string, name string) bool {
+	return common.Any(patterns, func(pattern string) bool {
+		matched, _ := path.Match(pattern, name)
+		return matched
+	})
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	return common.Any(prefixes, func(prefix netip.Prefix) bool {
+		return prefix.Contains(addr)
+	})
+}
+
+func (f *addrFilter) accept(addr localAddr) bool {
+	if !addr.external && (len(f.include) > 0 && !matchInterface(f.include, addr.iface) || matchInterface(f.exclude, addr.iface)) {
+		return false
+	}
+	if len(f.allow) > 0 && !containsAddr(f.allow, addr.Addr) || containsAddr(f.deny, addr.Addr) {
+		return false
+	}
+	return !(f.skipTemporary && addr.temporary() || f.skipDeprecated && addr.deprecated())
+}
+
+// apply returns the accepted addresses of the given types. With single, stable addresses
+// are preferred over temporary ones and preferred over deprecated ones, remaining ties
+// broken by the lowest address to keep the choice stable.
+func (f *addrFilter) apply(addrs []localAddr, types []string) []netip.Addr {
+	accepted := common.Filter(addrs, func(it localAddr) bool {
+		return common.Contains(types, addrType(it.Addr)) && f.accept(it)
+	})
+	if f.single {
+		sort.SliceStable(accepted, func(i, j int) bool {
+			a, b := accepted[i], accepted[j]
+			if a.deprecated() != b.deprecated() {
+				return !a.deprecated()
+			}
+			if a.temporary() != b.temporary() {
+				return !a.temporary()
+			}
+			return a.Less(b.Addr)
+		})
+	}
+	var result []netip.Addr
+	selected := make(map[string]bool)
+	for _, it := range accepted {
+		recordType := addrType(it.Addr)
+		if f.single && selected[recordType] {
+			continue
+		}
+		selected[recordType] = true
+		result = append(result, it.Addr)
+	}
+	return result
+}
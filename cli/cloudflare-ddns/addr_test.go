@@ -0,0 +1,126 @@
+//go:build linux
+
+package main
+
+import (
+	"net/netip"
+	"syscall"
+	"testing"
+)
+
+func testAddr(addr string, iface string, flags int) localAddr {
+	return localAddr{Addr: netip.MustParseAddr(addr), iface: iface, flags: flags}
+}
+
+func TestIsPublicAddr(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"1.1.1.1":      true,
+		"2001:db8::1":  true,
+		"10.0.0.1":     false,
+		"192.168.1.1":  false,
+		"fd00::1":      false,
+		"fe80::1":      false,
+		"127.0.0.1":    false,
+		"::1":          false,
+		"224.0.0.1":    false,
+		"0.0.0.0":      false,
+		"169.254.1.1":  false,
+		"2606:4700::1": true,
+	} {
+		if isPublicAddr(netip.MustParseAddr(addr)) != public {
+			t.Error(addr, ": expected public ", public)
+		}
+	}
+}
+
+func TestAddrFilter(t *testing.T) {
+	addrs := []localAddr{
+		testAddr("203.0.113.1", "eth0", 0),
+		testAddr("198.51.100.1", "wg0", 0),
+		testAddr("2001:db8::1", "eth0", 0),
+		testAddr("2001:db8::2", "eth0", syscall.IFA_F_TEMPORARY),
+		testAddr("2001:db8::3", "eth0", syscall.IFA_F_DEPRECATED),
+		testAddr("2001:db8:1::1", "docker0", 0),
+		{Addr: netip.MustParseAddr("192.0.2.1"), external: true},
+	}
+	both := []string{"A", "AAAA"}
+	for _, test := range []struct {
+		name     string
+		options  *FilterOptions
+		types    []string
+		expected []string
+	}{
+		{"all", nil, both, []string{"203.0.113.1", "198.51.100.1", "2001:db8::1", "2001:db8::2", "2001:db8::3", "2001:db8:1::1", "192.0.2.1"}},
+		{"types", nil, []string{"A"}, []string{"203.0.113.1", "198.51.100.1", "192.0.2.1"}},
+		{"include", &FilterOptions{IncludeInterfaces: []string{"eth*"}}, both, []string{"203.0.113.1", "2001:db8::1", "2001:db8::2", "2001:db8::3", "192.0.2.1"}},
+		{"exclude", &FilterOptions{ExcludeInterfaces: []string{"docker*", "wg0"}}, both, []string{"203.0.113.1", "2001:db8::1", "2001:db8::2", "2001:db8::3", "192.0.2.1"}},
+		{"allow", &FilterOptions{Allow: []string{"2001:db8::1/64"}}, both, []string{"2001:db8::1", "2001:db8::2", "2001:db8::3"}},
+		{"deny", &FilterOptions{Deny: []string{"198.51.100.0/24", "2001:db8:1::/48"}}, both, []string{"203.0.113.1", "2001:db8::1", "2001:db8::2", "2001:db8::3", "192.0.2.1"}},
+		{"skip temporary", &FilterOptions{SkipTemporary: true, IncludeInterfaces: []string{"eth0"}}, []string{"AAAA"}, []string{"2001:db8::1", "2001:db8::3"}},
+		{"skip deprecated", &FilterOptions{SkipDeprecated: true, IncludeInterfaces: []string{"eth0"}}, []string{"AAAA"}, []string{"2001:db8::1", "2001:db8::2"}},
+		{"single", &FilterOptions{Single: true, ExcludeInterfaces: []string{"docker0"}}, both, []string{"192.0.2.1", "2001:db8::1"}},
+	} {
+		filter, err := newAddrFilter(test.options)
+		if err != nil {
+			t.Fatal(test.name, ": ", err)
+		}
+		result := filter.apply(addrs, test.types)
+		if len(result) != len(test.expected) {
+			t.Error(test.name, ": expected ", test.expected, ", got ", result)
+			continue
+		}
+		for i, addr := range result {
+			if addr.String() != test.expected[i] {
+				t.Error(test.name, ": expected ", test.expected, ", got ", result)
+				break
+			}
+		}
+	}
+}
+
+func TestAddrFilterSinglePreference(t *testing.T) {
+	filter, err := newAddrFilter(&FilterOptions{Single: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		addrs    []localAddr
+		expected string
+	}{
+		{[]localAddr{
+			testAddr("2001:db8::1", "eth0", syscall.IFA_F_DEPRECATED),
+			testAddr("2001:db8::9", "eth0", syscall.IFA_F_TEMPORARY),
+		}, "2001:db8::9"},
+		{[]localAddr{
+			testAddr("2001:db8::1", "eth0", syscall.IFA_F_TEMPORARY),
+			testAddr("2001:db8::9", "eth0", 0),
+		}, "2001:db8::9"},
+		{[]localAddr{
+			testAddr("2001:db8::9", "eth0", 0),
+			testAddr("2001:db8::5", "eth1", 0),
+		}, "2001:db8::5"},
+		{[]localAddr{
+			testAddr("2001:db8::1", "eth0", syscall.IFA_F_TEMPORARY|syscall.IFA_F_DEPRECATED),
+			testAddr("2001:db8::9", "eth0", syscall.IFA_F_TEMPORARY),
+		}, "2001:db8::9"},
+	} {
+		result := filter.apply(test.addrs, []string{"AAAA"})
+		if len(result) != 1 || result[0].String() != test.expected {
+			t.Error("expected ", test.expected, ", got ", result)
+		}
+	}
+}
+
+func TestNewAddrFilterInvalid(t *testing.T) {
+	for _, options := range []*FilterOptions{
+		{IncludeInterfaces: []string{"eth["}},
+		{ExcludeInterfaces: []string{"["}},
+		{Allow: []string{"10.0.0.0/33"}},
+		{Deny: []string{"2001:db8::"}},
+	} {
+		_, err := newAddrFilter(options)
+		if err == nil {
+			t.Errorf("%+v: expected error", *options)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+//go:build linux
+
+package main
+
+import (
+	"strings"
+
+	"github.com/sagernet/sing-tools/extensions/publicip"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+type Config struct {
+	// Provider, APIToken, or APIKey and APIEmail, RFC2136 and Webhook are the defaults of
+	// records.
+	Provider string          `json:"provider"`
+	APIToken string          `json:"api_token"`
+	APIKey   string          `json:"api_key"`
+	APIEmail string          `json:"api_email"`
+	RFC2136  *RFC2136Options `json:"rfc2136"`
+	Webhook  *WebhookOptions `json:"webhook"`
+	Records  []RecordOptions `json:"records"`
+	// External discovers the public addresses of records with the external source, for
+	// hosts behind NAT.
+	External *publicip.Options `json:"external"`
+	// Interval of periodic reconciliation in seconds, 300 by default.
+	Interval int64 `json:"interval"`
+
+	// Domain and OverProxy configure a single record, as before records were added.
+	Domain    string `json:"domain"`
+	OverProxy bool   `json:"over_proxy"`
+}
+
+type RecordOptions struct {
+	Name string `json:"name"`
+	// Provider is one of cloudflare (default), rfc2136 and webhook.
+	Provider string `json:"provider"`
+	// Zone is the name of the zone containing the record, the longest matching zone
+	// of the account by default.
+	Zone     string          `json:"zone"`
+	APIToken string          `json:"api_token"`
+	APIKey   string          `json:"api_key"`
+	APIEmail string          `json:"api_email"`
+	RFC2136  *RFC2136Options `json:"rfc2136"`
+	Webhook  *WebhookOptions `json:"webhook"`
+	// TTL in seconds, 1 for automatic on Cloudflare. 60 by default.
+	TTL int `json:"ttl"`
+	// Proxied is only supported by Cloudflare.
+	Proxied bool `json:"proxied"`
+	// Types is a subset of A and AAAA, both by default.
+	Types []string `json:"types"`
+	// Source of addresses, local (default) for addresses of the interfaces or external
+	// for the addresses discovered by External.
+	Source string         `json:"source"`
+	Filter *FilterOptions `json:"filter"`
+}
+
+const (
+	SourceLocal    = "local"
+	SourceExternal = "external"
+)
+
+func (c *Config) records() ([]RecordOptions, error) {
+	records := c.Records
+	if c.Domain != "" {
+		records = append([]RecordOptions{{Name: c.Domain, Proxied: c.OverProxy}}, records...)
+	}
+	if len(records) == 0 {
+		return nil, E.New("missing records")
+	}
+	options := make([]RecordOptions, 0, len(records))
+	for _, record := range records {
+		record.Name = strings.ToLower(strings.TrimSuffix(record.Name, "."))
+		if record.Name == "" {
+			return nil, E.New("missing record name")
+		}
+		record.Zone = strings.ToLower(strings.TrimSuffix(record.Zone, "."))
+		if record.Provider == "" {
+			record.Provider = c.Provider
+		}
+		switch record.Provider {
+		case "":
+			record.Provider = ProviderCloudflare
+			fallthrough
+		case ProviderCloudflare:
+			if record.APIToken == "" && record.APIKey == "" {
+				record.APIToken, record.APIKey, record.APIEmail = c.APIToken, c.APIKey, c.APIEmail
+			}
+			if record.APIToken == "" && (record.APIKey == "" || record.APIEmail == "") {
+				return nil, E.New("record ", record.Name, ": missing api_token, or api_key and api_email")
+			}
+		case ProviderRFC2136, ProviderWebhook:
+			if record.Proxied {
+				return nil, E.New("record ", record.Name, ": proxied is only supported by cloudflare")
+			}
+			if record.RFC2136 == nil {
+				record.RFC2136 = c.RFC2136
+			}
+			if record.Webhook == nil {
+				record.Webhook = c.Webhook
+			}
+		default:
+			return nil, E.New("record ", record.Name, ": unknown provider ", record.Provider)
+		}
+		if record.TTL == 0 {
+			record.TTL = 60
+		}
+		if len(record.Types) == 0 {
+			record.Types = []string{"A", "AAAA"}
+		}
+		for i, recordType := range record.Types {
+			recordType = strings.ToUpper(recordType)
+			if recordType != "A" && recordType != "AAAA" {
+				return nil, E.New("record ", record.Name, ": unsupported type ", recordType)
+			}
+			record.Types[i] = recordType
+		}
+		switch record.Source {
+		case "":
+			record.Source = SourceLocal
+		case SourceLocal:
+		case SourceExternal:
+			if c.External == nil {
+				return nil, E.New("record ", record.Name, ": missing external sources")
+			}
+		default:
+			return nil, E.New("record ", record.Name, ": unknown source ", record.Source)
+		}
+		options = append(options, record)
+	}
+	return options, nil
+}
@@ -6,48 +6,41 @@ import (
 	"context"
 	"encoding/json"
 	"io/ioutil"
-	"net/netip"
-	"strings"
+	"os"
+	"time"
 
-	"github.com/cloudflare/cloudflare-go"
 	_ "github.com/sagernet/sing-tools/extensions/log"
+	"github.com/sagernet/sing-tools/extensions/publicip"
 	E "github.com/sagernet/sing/common/exceptions"
-	N "github.com/sagernet/sing/common/network"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"github.com/vishvananda/netlink"
 )
 
-var configPath string
+var (
+	configPath string
+	once       bool
+	dryRun     bool
+)
+
+// exit status of --once
+const (
+	exitFailure = 1
+	exitChanges = 2
+)
 
 func main() {
 	command := &cobra.Command{
-		Use: "cloudflare-ddns [-c config.json]",
+		Use: "cloudflare-ddns [-c config.json] [--once] [--dry-run]",
 		Run: run,
 	}
 	command.Flags().StringVarP(&configPath, "config", "c", "config.json", "set config path")
+	command.Flags().BoolVar(&once, "once", false, "reconcile once and exit with status 1 on failure")
+	command.Flags().BoolVar(&dryRun, "dry-run", false, "print planned changes without applying them, with --once exit with status 2 if there are any")
 	if err := command.Execute(); err != nil {
 		logrus.Fatal(err)
 	}
 }
 
-type Config struct {
-	APIKey    string `json:"api_key"`
-	APIEmail  string `json:"api_email"`
-	Domain    string `json:"domain"`
-	OverProxy bool   `json:"over_proxy"`
-}
-
-var (
-	domain    string
-	overProxy bool
-)
-
-var (
-	flare *cloudflare.API
-	zone  *cloudflare.Zone
-)
-
 func run(cmd *cobra.Command, args []string) {
 	c := new(Config)
 	cc, err := ioutil.ReadFile(configPath)
@@ -58,100 +51,46 @@ func run(cmd *cobra.Command, args []string) {
 	if err != nil {
 		logrus.Fatal(err)
 	}
-	domain = c.Domain
-	overProxy = c.OverProxy
-
-	flare, err = cloudflare.New(c.APIKey, c.APIEmail)
+	options, err := c.records()
 	if err != nil {
 		logrus.Fatal(err)
 	}
-
-	zone, err = findZoneForDomain(domain)
-	if err != nil {
-		logrus.Fatal(err)
+	u := &updater{
+		interval:   300 * time.Second,
+		localAddrs: localPublicAddrs,
+		dryRun:     dryRun,
+		output:     os.Stdout,
 	}
-
-	checkUpdate()
-
-	events := make(chan netlink.AddrUpdate, 1)
-	err = netlink.AddrSubscribe(events, nil)
+	if c.Interval > 0 {
+		u.interval = time.Duration(c.Interval) * time.Second
+	}
+	u.records, err = newRecords(options)
 	if err != nil {
 		logrus.Fatal(err)
 	}
-
-	for event := range events {
-		addr, _ := netip.AddrFromSlice(event.LinkAddress.IP)
-		if !N.IsPublicAddr(addr) {
-			continue
+	if c.External != nil {
+		u.external, err = publicip.NewResolver(*c.External)
+		if err != nil {
+			logrus.Fatal(E.Cause(err, "external"))
 		}
-		checkUpdate()
 	}
-}
-
-func findZoneForDomain(domain string) (*cloudflare.Zone, error) {
-	zones, err := flare.ListZones(context.Background())
-	if err != nil {
-		return nil, err
+	if !once {
+		u.loop()
+		return
 	}
-	for _, z := range zones {
-		if strings.HasSuffix(domain, z.Name) {
-			return &z, nil
-		}
-	}
-	return nil, E.New("unable to find zone for domain ", domain)
-}
-
-func checkUpdate() {
-	addrs, err := N.LocalPublicAddrs()
+	changed, err := u.reconcile(context.Background())
 	if err != nil {
-		logrus.Fatal(err)
-	}
-	addrMap := make(map[string]netip.Addr)
-	for _, addr := range addrs {
-		addrMap[addr.String()] = addr
-	}
-
-	if len(addrs) == 0 {
-		logrus.Warn("this device has no public addresses!")
+		logrus.Error(err)
 	}
+	os.Exit(onceStatus(changed, err, dryRun))
+}
 
-	records, err := flare.DNSRecords(context.Background(), zone.ID, cloudflare.DNSRecord{
-		Name: domain,
-	})
+func onceStatus(changed bool, err error, dryRun bool) int {
 	if err != nil {
-		logrus.Fatal(err)
-	}
-
-	for _, record := range records {
-		if !(record.Type == "A" || record.Type == "AAAA") {
-			continue
-		}
-		if _, exists := addrMap[record.Content]; !exists || record.Proxied == nil && overProxy || record.Proxied != nil && *record.Proxied != overProxy {
-			logrus.Info("Deleting ", record.Type, " ", record.Content)
-			err = flare.DeleteDNSRecord(context.Background(), zone.ID, record.ID)
-			if err != nil {
-				logrus.Fatal(err)
-			}
-		} else {
-			delete(addrMap, record.Content)
-		}
+		return exitFailure
 	}
-	for content, addr := range addrMap {
-		record := cloudflare.DNSRecord{
-			Name:    domain,
-			Content: content,
-			Proxied: &overProxy,
-			TTL:     60,
-		}
-		if addr.Is4() {
-			record.Type = "A"
-		} else {
-			record.Type = "AAAA"
-		}
-		logrus.Info("Adding ", record)
-		_, err = flare.CreateDNSRecord(context.Background(), zone.ID, record)
-		if err != nil {
-			logrus.Fatal(err)
-		}
+	if dryRun && changed {
+		return exitChanges
 	}
+	return 0
 }
@@ -0,0 +1,31 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+)
+
+const (
+	ProviderCloudflare = "cloudflare"
+	ProviderRFC2136    = "rfc2136"
+	ProviderWebhook    = "webhook"
+)
+
+type DNSRecord struct {
+	// ID identifies the record for the provider, the content if the provider has no IDs.
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+	Proxied bool   `json:"proxied,omitempty"`
+}
+
+// Provider manages the A and AAAA records of one name.
+type Provider interface {
+	Records(ctx context.Context) ([]DNSRecord, error)
+	Create(ctx context.Context, record DNSRecord) error
+	Update(ctx context.Context, old DNSRecord, record DNSRecord) error
+	Delete(ctx context.Context, record DNSRecord) error
+}
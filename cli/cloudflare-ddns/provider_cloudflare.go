@@ -0,0 +1,136 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+type cloudflareProvider struct {
+	api    *cloudflare.API
+	name   string
+	zone   string
+	zoneID string
+}
+
+type credentials struct {
+	token, key, email string
+}
+
+// newCloudflareProvider creates a provider of the record, sharing API clients per account.
+func newCloudflareProvider(clients map[credentials]*cloudflare.API, options RecordOptions) (*cloudflareProvider, error) {
+	account := credentials{options.APIToken, options.APIKey, options.APIEmail}
+	api, loaded := clients[account]
+	if !loaded {
+		var err error
+		if options.APIToken != "" {
+			api, err = cloudflare.NewWithAPIToken(options.APIToken)
+		} else {
+			api, err = cloudflare.New(options.APIKey, options.APIEmail)
+		}
+		if err != nil {
+			return nil, err
+		}
+		clients[account] = api
+	}
+	return &cloudflareProvider{api: api, name: options.Name, zone: options.Zone}, nil
+}
+
+// findZone returns the zone named zone, or the zone matching most labels of the record name.
+func (p *cloudflareProvider) findZone(ctx context.Context) (string, error) {
+	if p.zoneID != "" {
+		return p.zoneID, nil
+	}
+	var options []cloudflare.ReqOption
+	if p.zone != "" {
+		options = append(options, cloudflare.WithZoneFilters(p.zone, "", ""))
+	}
+	zones, err := p.api.ListZonesContext(ctx, options...)
+	if err != nil {
+		return "", E.Cause(err, "list zones")
+	}
+	var match cloudflare.Zone
+	for _, zone := range zones.Result {
+		name := strings.ToLower(zone.Name)
+		if p.zone != "" {
+			if name == p.zone {
+				match = zone
+				break
+			}
+		} else if (p.name == name || strings.HasSuffix(p.name, "."+name)) && len(name) > len(match.Name) {
+			match = zone
+		}
+	}
+	if match.ID == "" {
+		return "", E.New("unable to find zone for domain ", p.name)
+	}
+	p.zoneID = match.ID
+	return p.zoneID, nil
+}
+
+func (p *cloudflareProvider) Records(ctx context.Context) ([]DNSRecord, error) {
+	zoneID, err := p.findZone(ctx)
+	if err != nil {
+		return nil, err
+	}
+	records, err := p.api.DNSRecords(ctx, zoneID, cloudflare.DNSRecord{
+		Name: p.name,
+	})
+	if err != nil {
+		return nil, E.Cause(err, "list records")
+	}
+	var result []DNSRecord
+	for _, record := range records {
+		if record.Type != "A" && record.Type != "AAAA" {
+			continue
+		}
+		result = append(result, DNSRecord{
+			ID:      record.ID,
+			Type:    record.Type,
+			Name:    record.Name,
+			Content: record.Content,
+			TTL:     record.TTL,
+			Proxied: record.Proxied != nil && *record.Proxied,
+		})
+	}
+	return result, nil
+}
+
+func toCloudflareRecord(record DNSRecord) cloudflare.DNSRecord {
+	return cloudflare.DNSRecord{
+		Type:    record.Type,
+		Name:    record.Name,
+		Content: record.Content,
+		Proxied: &record.Proxied,
+		TTL:     record.TTL,
+	}
+}
+
+func (p *cloudflareProvider) Create(ctx context.Context, record DNSRecord) error {
+	zoneID, err := p.findZone(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = p.api.CreateDNSRecord(ctx, zoneID, toCloudflareRecord(record))
+	return err
+}
+
+func (p *cloudflareProvider) Update(ctx context.Context, old DNSRecord, record DNSRecord) error {
+	zoneID, err := p.findZone(ctx)
+	if err != nil {
+		return err
+	}
+	return p.api.UpdateDNSRecord(ctx, zoneID, old.ID, toCloudflareRecord(record))
+}
+
+func (p *cloudflareProvider) Delete(ctx context.Context, record DNSRecord) error {
+	zoneID, err := p.findZone(ctx)
+	if err != nil {
+		return err
+	}
+	return p.api.DeleteDNSRecord(ctx, zoneID, record.ID)
+}
@@ -0,0 +1,208 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+type RFC2136Options struct {
+	// Server is the primary nameserver of the zone, port 53 by default.
+	Server string `json:"server"`
+	// Zone containing the record if the zone of the record is not set, looked up by a SOA
+	// query to Server by default.
+	Zone string `json:"zone"`
+	// TSIGKey and TSIGSecret sign updates if set, the algorithm defaults to hmac-sha256.
+	TSIGKey       string `json:"tsig_key"`
+	TSIGSecret    string `json:"tsig_secret"`
+	TSIGAlgorithm string `json:"tsig_algorithm"`
+}
+
+// rfc2136Provider reads records from and sends dynamic updates (RFC 2136) to the
+// primary nameserver of the zone. Records have no IDs, an update replaces the old
+// record with the new one in a single message.
+type rfc2136Provider struct {
+	server        string
+	zone          string
+	name          string
+	tsigKey       string
+	tsigSecret    string
+	tsigAlgorithm string
+}
+
+func newRFC2136Provider(name string, zone string, options *RFC2136Options) (*rfc2136Provider, error) {
+	if options == nil || options.Server == "" {
+		return nil, E.New("rfc2136: missing server")
+	}
+	server := options.Server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	provider := &rfc2136Provider{
+		server: server,
+		name:   dns.Fqdn(name),
+	}
+	if zone == "" {
+		zone = options.Zone
+	}
+	if zone != "" {
+		provider.zone = dns.Fqdn(strings.ToLower(zone))
+		if !dns.IsSubDomain(provider.zone, provider.name) {
+			return nil, E.New("rfc2136: ", name, " is not in zone ", zone)
+		}
+	}
+	if options.TSIGKey != "" {
+		if options.TSIGSecret == "" {
+			return nil, E.New("rfc2136: missing tsig_secret")
+		}
+		provider.tsigKey = dns.Fqdn(options.TSIGKey)
+		provider.tsigSecret = options.TSIGSecret
+		provider.tsigAlgorithm = dns.HmacSHA256
+		if options.TSIGAlgorithm != "" {
+			provider.tsigAlgorithm = dns.Fqdn(strings.ToLower(options.TSIGAlgorithm))
+		}
+	}
+	return provider, nil
+}
+
+var errNameNotFound = E.New(dns.RcodeToString[dns.RcodeNameError])
+
+// exchange returns the response together with errNameNotFound if the name does not exist.
+func (p *rfc2136Provider) exchange(ctx context.Context, message *dns.Msg) (*dns.Msg, error) {
+	client := &dns.Client{Net: "tcp", Timeout: 10 * time.Second}
+	if p.tsigKey != "" {
+		client.TsigSecret = map[string]string{p.tsigKey: p.tsigSecret}
+		message.SetTsig(p.tsigKey, p.tsigAlgorithm, 300, time.Now().Unix())
+	}
+	response, _, err := client.ExchangeContext(ctx, message, p.server)
+	if err != nil {
+		return nil, err
+	}
+	switch response.Rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNameError:
+		return response, errNameNotFound
+	default:
+		return nil, E.New(dns.RcodeToString[response.Rcode])
+	}
+	return response, nil
+}
+
+// findZone returns the configured zone, or the owner of the SOA record of the name.
+func (p *rfc2136Provider) findZone(ctx context.Context) (string, error) {
+	if p.zone != "" {
+		return p.zone, nil
+	}
+	message := new(dns.Msg)
+	message.SetQuestion(p.name, dns.TypeSOA)
+	response, err := p.exchange(ctx, message)
+	if err != nil && err != errNameNotFound {
+		return "", E.Cause(err, "rfc2136: query SOA of ", p.name)
+	}
+	for _, answer := range append(response.Answer, response.Ns...) {
+		if soa, isSOA := answer.(*dns.SOA); isSOA {
+			p.zone = strings.ToLower(soa.Hdr.Name)
+			return p.zone, nil
+		}
+	}
+	return "", E.New("rfc2136: no SOA record for ", p.name)
+}
+
+func (p *rfc2136Provider) Records(ctx context.Context) ([]DNSRecord, error) {
+	var records []DNSRecord
+	for _, queryType := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		message := new(dns.Msg)
+		message.SetQuestion(p.name, queryType)
+		message.RecursionDesired = false
+		response, err := p.exchange(ctx, message)
+		if err == errNameNotFound {
+			// the name is created by the first update
+			break
+		} else if err != nil {
+			return nil, E.Cause(err, "rfc2136: query ", dns.TypeToString[queryType], " of ", p.name)
+		}
+		for _, answer := range response.Answer {
+			var addr netip.Addr
+			switch record := answer.(type) {
+			case *dns.A:
+				addr, _ = netip.AddrFromSlice(record.A.To4())
+			case *dns.AAAA:
+				addr, _ = netip.AddrFromSlice(record.AAAA)
+			default:
+				continue
+			}
+			if !strings.EqualFold(answer.Header().Name, p.name) {
+				continue
+			}
+			records = append(records, DNSRecord{
+				ID:      addr.String(),
+				Type:    dns.TypeToString[answer.Header().Rrtype],
+				Name:    strings.TrimSuffix(p.name, "."),
+				Content: addr.String(),
+				TTL:     int(answer.Header().Ttl),
+			})
+		}
+	}
+	return records, nil
+}
+
+func (p *rfc2136Provider) newRR(record DNSRecord) (dns.RR, error) {
+	addr, err := netip.ParseAddr(record.Content)
+	if err != nil {
+		return nil, err
+	}
+	header := dns.RR_Header{Name: p.name, Class: dns.ClassINET, Ttl: uint32(record.TTL)}
+	if addr.Is4() {
+		header.Rrtype = dns.TypeA
+		return &dns.A{Hdr: header, A: addr.AsSlice()}, nil
+	}
+	header.Rrtype = dns.TypeAAAA
+	return &dns.AAAA{Hdr: header, AAAA: addr.AsSlice()}, nil
+}
+
+func (p *rfc2136Provider) update(ctx context.Context, remove *DNSRecord, insert *DNSRecord) error {
+	zone, err := p.findZone(ctx)
+	if err != nil {
+		return err
+	}
+	message := new(dns.Msg)
+	message.SetUpdate(zone)
+	if remove != nil {
+		rr, err := p.newRR(*remove)
+		if err != nil {
+			return err
+		}
+		message.Remove([]dns.RR{rr})
+	}
+	if insert != nil {
+		rr, err := p.newRR(*insert)
+		if err != nil {
+			return err
+		}
+		message.Insert([]dns.RR{rr})
+	}
+	_, err = p.exchange(ctx, message)
+	if err != nil {
+		return E.Cause(err, "rfc2136: update")
+	}
+	return nil
+}
+
+func (p *rfc2136Provider) Create(ctx context.Context, record DNSRecord) error {
+	return p.update(ctx, nil, &record)
+}
+
+func (p *rfc2136Provider) Update(ctx context.Context, old DNSRecord, record DNSRecord) error {
+	return p.update(ctx, &old, &record)
+}
+
+func (p *rfc2136Provider) Delete(ctx context.Context, record DNSRecord) error {
+	return p.update(ctx, &record, nil)
+}
@@ -0,0 +1,188 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const testTSIGSecret = "c2VjcmV0c2VjcmV0c2VjcmV0"
+
+// testZone is an authoritative stand-in for example.com that accepts signed updates.
+type testZone struct {
+	access  sync.Mutex
+	records []dns.RR
+	updates []string
+}
+
+func (z *testZone) ServeDNS(w dns.ResponseWriter, request *dns.Msg) {
+	z.access.Lock()
+	defer z.access.Unlock()
+	response := new(dns.Msg)
+	response.SetReply(request)
+	defer func() {
+		if request.IsTsig() != nil {
+			response.SetTsig(request.IsTsig().Hdr.Name, dns.HmacSHA256, 300, time.Now().Unix())
+		}
+		w.WriteMsg(response)
+	}()
+	if request.IsTsig() == nil || w.TsigStatus() != nil {
+		response.Rcode = dns.RcodeNotAuth
+		return
+	}
+	question := request.Question[0]
+	if request.Opcode == dns.OpcodeUpdate {
+		z.updates = append(z.updates, question.Name)
+		for _, rr := range request.Ns {
+			if rr.Header().Class == dns.ClassNONE {
+				rr.Header().Class = dns.ClassINET
+				for i := 0; i < len(z.records); i++ {
+					if dns.IsDuplicate(z.records[i], rr) {
+						z.records = append(z.records[:i], z.records[i+1:]...)
+						i--
+					}
+				}
+			} else {
+				z.records = append(z.records, rr)
+			}
+		}
+		return
+	}
+	var exists bool
+	for _, record := range z.records {
+		if strings.EqualFold(record.Header().Name, question.Name) {
+			exists = true
+			if record.Header().Rrtype == question.Qtype {
+				response.Answer = append(response.Answer, record)
+			}
+		}
+	}
+	if !exists {
+		response.Rcode = dns.RcodeNameError
+	}
+	if question.Qtype == dns.TypeSOA || len(response.Answer) == 0 {
+		soa, _ := dns.NewRR("example.com. 60 IN SOA ns.example.com. hostmaster.example.com. 1 7200 900 1209600 60")
+		response.Ns = append(response.Ns, soa)
+	}
+}
+
+func startTestZone(t *testing.T) (*testZone, string) {
+	zone := new(testZone)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{
+		Listener:   listener,
+		TsigSecret: map[string]string{"ddns.": testTSIGSecret},
+		Handler:    zone,
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction {
+			return dns.MsgAccept
+		},
+	}
+	go server.ActivateAndServe()
+	t.Cleanup(func() {
+		server.Shutdown()
+	})
+	return zone, listener.Addr().String()
+}
+
+func recordContents(t *testing.T, provider Provider) []string {
+	t.Helper()
+	records, err := provider.Records(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contents []string
+	for _, record := range records {
+		contents = append(contents, record.Type+" "+record.Content)
+	}
+	sort.Strings(contents)
+	return contents
+}
+
+func TestRFC2136Provider(t *testing.T) {
+	zone, server := startTestZone(t)
+	provider, err := newRFC2136Provider("home.example.com", "", &RFC2136Options{
+		Server:     server,
+		TSIGKey:    "ddns",
+		TSIGSecret: testTSIGSecret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if contents := recordContents(t, provider); len(contents) != 0 {
+		t.Fatal("expected no records, got ", contents)
+	}
+
+	err = provider.Create(ctx, DNSRecord{Type: "A", Name: "home.example.com", Content: "198.51.100.1", TTL: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.zone != "example.com." {
+		t.Fatal("unexpected zone ", provider.zone)
+	}
+	err = provider.Create(ctx, DNSRecord{Type: "AAAA", Name: "home.example.com", Content: "2001:db8::1", TTL: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents := strings.Join(recordContents(t, provider), ","); contents != "A 198.51.100.1,AAAA 2001:db8::1" {
+		t.Fatal("unexpected records ", contents)
+	}
+
+	records, err := provider.Records(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var old DNSRecord
+	for _, record := range records {
+		if record.Type == "A" {
+			old = record
+		}
+	}
+	err = provider.Update(ctx, old, DNSRecord{Type: "A", Name: "home.example.com", Content: "203.0.113.7", TTL: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents := strings.Join(recordContents(t, provider), ","); contents != "A 203.0.113.7,AAAA 2001:db8::1" {
+		t.Fatal("unexpected records ", contents)
+	}
+
+	err = provider.Delete(ctx, DNSRecord{Type: "AAAA", Name: "home.example.com", Content: "2001:db8::1", TTL: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents := strings.Join(recordContents(t, provider), ","); contents != "A 203.0.113.7" {
+		t.Fatal("unexpected records ", contents)
+	}
+	for _, name := range zone.updates {
+		if name != "example.com." {
+			t.Fatal("update sent to zone ", name)
+		}
+	}
+}
+
+func TestRFC2136ProviderBadTSIG(t *testing.T) {
+	_, server := startTestZone(t)
+	provider, err := newRFC2136Provider("home.example.com", "example.com", &RFC2136Options{
+		Server:     server,
+		TSIGKey:    "ddns",
+		TSIGSecret: "d3JvbmdzZWNyZXR3cm9uZw==",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = provider.Create(context.Background(), DNSRecord{Type: "A", Name: "home.example.com", Content: "198.51.100.1", TTL: 60})
+	if err == nil {
+		t.Fatal("expected update with a bad key to fail")
+	}
+}
@@ -0,0 +1,112 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+type WebhookOptions struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+// webhookProvider posts every operation as JSON to a URL:
+//
+//	{"action": "list", "name": "home.example.com"}
+//	{"action": "create", "name": ..., "record": {"type": "A", "name": ..., "content": ..., "ttl": 60}}
+//	{"action": "update", "name": ..., "old": {...}, "record": {...}}
+//	{"action": "delete", "name": ..., "record": {...}}
+//
+// Any 2xx status is success. The response of list is {"records": [...]}, where
+// records may carry an id passed back in old and record of later requests.
+type webhookProvider struct {
+	client  *http.Client
+	url     string
+	headers map[string]string
+	name    string
+}
+
+type webhookRequest struct {
+	Action string     `json:"action"`
+	Name   string     `json:"name"`
+	Old    *DNSRecord `json:"old,omitempty"`
+	Record *DNSRecord `json:"record,omitempty"`
+}
+
+type webhookListResponse struct {
+	Records []DNSRecord `json:"records"`
+}
+
+func newWebhookProvider(name string, options *WebhookOptions) (*webhookProvider, error) {
+	if options == nil || options.URL == "" {
+		return nil, E.New("webhook: missing url")
+	}
+	return &webhookProvider{
+		client:  &http.Client{Timeout: 30 * time.Second},
+		url:     options.URL,
+		headers: options.Headers,
+		name:    name,
+	}, nil
+}
+
+func (p *webhookProvider) post(ctx context.Context, request webhookRequest, response any) error {
+	request.Name = p.name
+	content, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	for key, value := range p.headers {
+		httpRequest.Header.Set(key, value)
+	}
+	httpResponse, err := p.client.Do(httpRequest)
+	if err != nil {
+		return E.Cause(err, "webhook: ", request.Action)
+	}
+	defer httpResponse.Body.Close()
+	if httpResponse.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(httpResponse.Body, 512))
+		return E.New("webhook: ", request.Action, ": HTTP ", httpResponse.StatusCode, " ", string(bytes.TrimSpace(message)))
+	}
+	if response == nil {
+		return nil
+	}
+	err = json.NewDecoder(httpResponse.Body).Decode(response)
+	if err != nil {
+		return E.Cause(err, "webhook: parse ", request.Action, " response")
+	}
+	return nil
+}
+
+func (p *webhookProvider) Records(ctx context.Context) ([]DNSRecord, error) {
+	var response webhookListResponse
+	err := p.post(ctx, webhookRequest{Action: "list"}, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Records, nil
+}
+
+func (p *webhookProvider) Create(ctx context.Context, record DNSRecord) error {
+	return p.post(ctx, webhookRequest{Action: "create", Record: &record}, nil)
+}
+
+func (p *webhookProvider) Update(ctx context.Context, old DNSRecord, record DNSRecord) error {
+	return p.post(ctx, webhookRequest{Action: "update", Old: &old, Record: &record}, nil)
+}
+
+func (p *webhookProvider) Delete(ctx context.Context, record DNSRecord) error {
+	return p.post(ctx, webhookRequest{Action: "delete", Record: &record}, nil)
+}
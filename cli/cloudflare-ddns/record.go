@@ -0,0 +1,191 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"net/netip"
+	"sort"
+	"strconv"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/sagernet/sing/common"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sirupsen/logrus"
+)
+
+type record struct {
+	RecordOptions
+	provider Provider
+	filter   *addrFilter
+}
+
+func newRecords(options []RecordOptions) ([]*record, error) {
+	clients := make(map[credentials]*cloudflare.API)
+	var records []*record
+	for _, it := range options {
+		var (
+			provider Provider
+			err      error
+		)
+		switch it.Provider {
+		case ProviderCloudflare:
+			provider, err = newCloudflareProvider(clients, it)
+		case ProviderRFC2136:
+			provider, err = newRFC2136Provider(it.Name, it.Zone, it.RFC2136)
+		case ProviderWebhook:
+			provider, err = newWebhookProvider(it.Name, it.Webhook)
+		}
+		if err != nil {
+			return nil, E.Cause(err, "record ", it.Name)
+		}
+		filter, err := newAddrFilter(it.Filter)
+		if err != nil {
+			return nil, E.Cause(err, "record ", it.Name)
+		}
+		records = append(records, &record{RecordOptions: it, provider: provider, filter: filter})
+	}
+	return records, nil
+}
+
+func (r *record) String() string {
+	return r.Name
+}
+
+func addrType(addr netip.Addr) string {
+	if addr.Is4() {
+		return "A"
+	}
+	return "AAAA"
+}
+
+// matches reports whether the proxied flag and TTL of the record are as configured.
+// Cloudflare sets the TTL of proxied records to automatic.
+func (r *record) matches(record DNSRecord) bool {
+	return record.Proxied == r.Proxied && (r.Proxied || record.TTL == r.TTL)
+}
+
+func (r *record) newRecord(recordType string, content string) DNSRecord {
+	return DNSRecord{
+		Type:    recordType,
+		Name:    r.Name,
+		Content: content,
+		Proxied: r.Proxied,
+		TTL:     r.TTL,
+	}
+}
+
+const (
+	actionCreate = "create"
+	actionUpdate = "update"
+	actionDelete = "delete"
+)
+
+type change struct {
+	action string
+	old    DNSRecord
+	record DNSRecord
+}
+
+func formatRecord(record DNSRecord) string {
+	content := record.Type + " " + record.Content + " ttl " + strconv.Itoa(record.TTL)
+	if record.Proxied {
+		content += " proxied"
+	}
+	return content
+}
+
+// diff formats the change like a line-based diff of the records.
+func (c change) diff() string {
+	switch c.action {
+	case actionCreate:
+		return "+ " + formatRecord(c.record)
+	case actionDelete:
+		return "- " + formatRecord(c.old)
+	default:
+		return "- " + formatRecord(c.old) + "\n+ " + formatRecord(c.record)
+	}
+}
+
+// plan returns the changes making the records of types match the filtered addresses.
+// Records are changed in place where possible, so that the name keeps resolving, and
+// deleted last.
+func (r *record) plan(ctx context.Context, localAddrs []localAddr, types []string) ([]change, error) {
+	addrMap := make(map[string]netip.Addr)
+	for _, addr := range r.filter.apply(localAddrs, types) {
+		addrMap[addr.String()] = addr
+	}
+
+	records, err := r.provider.Records(ctx)
+	if err != nil {
+		return nil, E.Cause(err, "list records")
+	}
+
+	var (
+		changes []change
+		stale   []DNSRecord
+	)
+	for _, record := range records {
+		if !common.Contains(types, record.Type) {
+			continue
+		}
+		if _, exists := addrMap[record.Content]; !exists {
+			stale = append(stale, record)
+			continue
+		}
+		delete(addrMap, record.Content)
+		if !r.matches(record) {
+			changes = append(changes, change{actionUpdate, record, r.newRecord(record.Type, record.Content)})
+		}
+	}
+	contents := make([]string, 0, len(addrMap))
+	for content := range addrMap {
+		contents = append(contents, content)
+	}
+	sort.Strings(contents)
+	for _, content := range contents {
+		newRecord := r.newRecord(addrType(addrMap[content]), content)
+		index := -1
+		for i, record := range stale {
+			if record.Type == newRecord.Type {
+				index = i
+				break
+			}
+		}
+		if index >= 0 {
+			changes = append(changes, change{actionUpdate, stale[index], newRecord})
+			stale = append(stale[:index], stale[index+1:]...)
+			continue
+		}
+		changes = append(changes, change{action: actionCreate, record: newRecord})
+	}
+	for _, record := range stale {
+		changes = append(changes, change{action: actionDelete, old: record})
+	}
+	return changes, nil
+}
+
+func (r *record) apply(ctx context.Context, changes []change) error {
+	for _, it := range changes {
+		var err error
+		switch it.action {
+		case actionCreate:
+			logrus.Info("[", r.Name, "] adding ", it.record.Type, " ", it.record.Content)
+			err = r.provider.Create(ctx, it.record)
+		case actionUpdate:
+			if it.old.Content == it.record.Content {
+				logrus.Info("[", r.Name, "] updating ", it.old.Type, " ", it.old.Content)
+			} else {
+				logrus.Info("[", r.Name, "] updating ", it.old.Type, " ", it.old.Content, " to ", it.record.Content)
+			}
+			err = r.provider.Update(ctx, it.old, it.record)
+		case actionDelete:
+			logrus.Info("[", r.Name, "] deleting ", it.old.Type, " ", it.old.Content)
+			err = r.provider.Delete(ctx, it.old)
+		}
+		if err != nil {
+			return E.Cause(err, it.action, " record")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+// memoryProvider keeps records in memory and counts changes.
+type memoryProvider struct {
+	records []DNSRecord
+	changes int
+	err     error
+}
+
+func (p *memoryProvider) Records(ctx context.Context) ([]DNSRecord, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	return append([]DNSRecord(nil), p.records...), nil
+}
+
+func (p *memoryProvider) Create(ctx context.Context, record DNSRecord) error {
+	p.changes++
+	p.records = append(p.records, record)
+	return nil
+}
+
+func (p *memoryProvider) Update(ctx context.Context, old DNSRecord, record DNSRecord) error {
+	p.changes++
+	for i, it := range p.records {
+		if it == old {
+			p.records[i] = record
+			return nil
+		}
+	}
+	return E.New("record not found")
+}
+
+func (p *memoryProvider) Delete(ctx context.Context, record DNSRecord) error {
+	p.changes++
+	for i, it := range p.records {
+		if it == record {
+			p.records = append(p.records[:i], p.records[i+1:]...)
+			return nil
+		}
+	}
+	return E.New("record not found")
+}
+
+func newTestRecord(t *testing.T, provider Provider, filter *FilterOptions) *record {
+	addrFilter, err := newAddrFilter(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &record{
+		RecordOptions: RecordOptions{Name: "home.example.com", TTL: 60, Types: []string{"A", "AAAA"}},
+		provider:      provider,
+		filter:        addrFilter,
+	}
+}
+
+func TestPlanDiff(t *testing.T) {
+	provider := &memoryProvider{records: []DNSRecord{
+		{Type: "A", Name: "home.example.com", Content: "198.51.100.1", TTL: 60},
+		{Type: "AAAA", Name: "home.example.com", Content: "2001:db8::1", TTL: 300},
+		{Type: "AAAA", Name: "home.example.com", Content: "2001:db8::dead", TTL: 60},
+		{Type: "TXT", Name: "home.example.com", Content: "v=spf1 -all", TTL: 60},
+	}}
+	record := newTestRecord(t, provider, nil)
+	changes, err := record.plan(context.Background(), []localAddr{
+		testAddr("203.0.113.1", "eth0", 0),
+		testAddr("203.0.113.2", "eth0", 0),
+		testAddr("2001:db8::1", "eth0", 0),
+	}, record.Types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff := make([]string, 0, len(changes))
+	for _, it := range changes {
+		diff = append(diff, it.diff())
+	}
+	expected := strings.Join([]string{
+		// wrong TTL
+		"- AAAA 2001:db8::1 ttl 300\n+ AAAA 2001:db8::1 ttl 60",
+		// changed in place
+		"- A 198.51.100.1 ttl 60\n+ A 203.0.113.1 ttl 60",
+		"+ A 203.0.113.2 ttl 60",
+		"- AAAA 2001:db8::dead ttl 60",
+	}, "\n")
+	if result := strings.Join(diff, "\n"); result != expected {
+		t.Fatalf("expected diff\n%s\ngot\n%s", expected, result)
+	}
+
+	err = record.apply(context.Background(), changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err = record.plan(context.Background(), []localAddr{
+		testAddr("203.0.113.2", "eth0", 0),
+		testAddr("203.0.113.1", "eth0", 0),
+		testAddr("2001:db8::1", "eth0", 0),
+	}, record.Types)
+	if err != nil || len(changes) != 0 {
+		t.Fatal("expected no changes after apply, got ", changes, err)
+	}
+}
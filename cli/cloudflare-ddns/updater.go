@@ -0,0 +1,242 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/sagernet/sing-tools/extensions/publicip"
+	"github.com/sagernet/sing/common"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// address events are applied once no further event arrived for debounceDelay, but
+	// at most debounceMax after the first one
+	debounceDelay = 2 * time.Second
+	debounceMax   = 30 * time.Second
+	retryMin      = 5 * time.Second
+	retryMax      = 5 * time.Minute
+)
+
+type updater struct {
+	records  []*record
+	external *publicip.Resolver
+	interval time.Duration
+	// localAddrs returns the public addresses of the interfaces
+	localAddrs func() ([]localAddr, error)
+	// dryRun prints the planned changes to output instead of applying them
+	dryRun bool
+	output io.Writer
+}
+
+// loop reconciles all records at start, after address changes and every interval,
+// retrying failures with exponential backoff.
+func (u *updater) loop() {
+	events := make(chan struct{}, 1)
+	go subscribeAddrUpdates(events)
+	timer := time.NewTimer(0)
+	var (
+		failures     int
+		pendingSince time.Time
+	)
+	for {
+		select {
+		case <-events:
+			now := time.Now()
+			if pendingSince.IsZero() {
+				pendingSince = now
+			}
+			delay := debounce(pendingSince, now)
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(delay)
+		case <-timer.C:
+			pendingSince = time.Time{}
+			_, err := u.reconcile(context.Background())
+			if err == nil {
+				failures = 0
+				timer.Reset(u.interval)
+				continue
+			}
+			failures++
+			delay := u.retryDelay(failures)
+			logrus.Error(err, ", retrying in ", delay)
+			timer.Reset(delay)
+		}
+	}
+}
+
+// debounce returns the delay until events pending since pendingSince are applied, after
+// another event at now.
+func debounce(pendingSince time.Time, now time.Time) time.Duration {
+	delay := debounceDelay
+	if deadline := pendingSince.Add(debounceMax); now.Add(delay).After(deadline) {
+		delay = deadline.Sub(now)
+	}
+	return delay
+}
+
+// retryDelay is the delay after failed reconciliations, at most the interval.
+func (u *updater) retryDelay(failures int) time.Duration {
+	delay := retryDelay(failures)
+	if delay > u.interval {
+		delay = u.interval
+	}
+	return delay
+}
+
+func retryDelay(failures int) time.Duration {
+	delay := retryMin
+	for i := 1; i < failures && delay < retryMax; i++ {
+		delay *= 2
+	}
+	if delay > retryMax {
+		delay = retryMax
+	}
+	return delay
+}
+
+func notify(events chan<- struct{}) {
+	select {
+	case events <- struct{}{}:
+	default:
+	}
+}
+
+// subscribeAddrUpdates notifies events of public address changes, subscribing again
+// whenever the netlink subscription fails.
+func subscribeAddrUpdates(events chan<- struct{}) {
+	var failures int
+	for {
+		updates := make(chan netlink.AddrUpdate, 16)
+		err := netlink.AddrSubscribeWithOptions(updates, nil, netlink.AddrSubscribeOptions{
+			ErrorCallback: func(err error) {
+				logrus.Warn("address subscription: ", err)
+			},
+		})
+		if err != nil {
+			failures++
+			delay := retryDelay(failures)
+			logrus.Error("subscribe address updates: ", err, ", retrying in ", delay)
+			time.Sleep(delay)
+			continue
+		}
+		if failures > 0 {
+			// changes may have been missed while not subscribed
+			notify(events)
+		}
+		failures = 0
+		for update := range updates {
+			addr, _ := netip.AddrFromSlice(update.LinkAddress.IP)
+			if isPublicAddr(addr.Unmap()) {
+				notify(events)
+			}
+		}
+		failures++
+		logrus.Warn("address subscription closed, subscribing again")
+		time.Sleep(retryDelay(failures))
+	}
+}
+
+// externalAddrs looks up the external address of each type used by external records.
+// Types which failed are not returned, to keep their records unchanged.
+func (u *updater) externalAddrs(ctx context.Context) ([]localAddr, []string, []string) {
+	var types []string
+	for _, record := range u.records {
+		if record.Source == SourceExternal {
+			types = common.Uniq(append(types, record.Types...))
+		}
+	}
+	var (
+		addrs     []localAddr
+		available []string
+		failed    []string
+	)
+	for _, recordType := range types {
+		network := publicip.NetworkIPv4
+		if recordType == "AAAA" {
+			network = publicip.NetworkIPv6
+		}
+		addr, err := u.external.Lookup(ctx, network)
+		if errors.Is(err, publicip.ErrUnsupported) {
+			logrus.Debug("no external source supports ", network)
+			continue
+		} else if err != nil {
+			logrus.Error("lookup external ", network, " address: ", err)
+			failed = append(failed, "external "+network+" address")
+			continue
+		}
+		addrs = append(addrs, localAddr{Addr: addr, external: true})
+		available = append(available, recordType)
+	}
+	return addrs, available, failed
+}
+
+// reconcile updates all records, continuing with the others if one fails, and reports
+// whether any record was changed, or would have been in dry run.
+func (u *updater) reconcile(ctx context.Context) (bool, error) {
+	addrs, err := u.localAddrs()
+	if err != nil {
+		return false, err
+	}
+
+	var (
+		externalAddrs []localAddr
+		externalTypes []string
+		failed        []string
+	)
+	if u.external != nil {
+		externalAddrs, externalTypes, failed = u.externalAddrs(ctx)
+	}
+
+	if len(addrs) == 0 && len(externalAddrs) == 0 {
+		logrus.Warn("this device has no public addresses!")
+	}
+
+	var changed bool
+	for _, record := range u.records {
+		recordAddrs, types := addrs, record.Types
+		if record.Source == SourceExternal {
+			recordAddrs = externalAddrs
+			types = common.Filter(types, func(it string) bool {
+				return common.Contains(externalTypes, it)
+			})
+			if len(types) == 0 {
+				continue
+			}
+		}
+		changes, err := record.plan(ctx, recordAddrs, types)
+		if err == nil && len(changes) > 0 {
+			changed = true
+			if u.dryRun {
+				fmt.Fprintln(u.output, record.Name+":")
+				for _, it := range changes {
+					fmt.Fprintln(u.output, it.diff())
+				}
+				continue
+			}
+			err = record.apply(ctx, changes)
+		}
+		if err != nil {
+			logrus.Error("update ", record, ": ", err)
+			failed = append(failed, record.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return changed, E.New("failed to update ", strings.Join(failed, ", "))
+	}
+	return changed, nil
+}
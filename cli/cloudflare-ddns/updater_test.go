@@ -0,0 +1,122 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+func TestRetryDelay(t *testing.T) {
+	for failures, expected := range map[int]time.Duration{
+		1:  5 * time.Second,
+		2:  10 * time.Second,
+		3:  20 * time.Second,
+		6:  160 * time.Second,
+		7:  retryMax,
+		20: retryMax,
+	} {
+		if delay := retryDelay(failures); delay != expected {
+			t.Error(failures, " failures: expected ", expected, ", got ", delay)
+		}
+	}
+	u := &updater{interval: time.Minute}
+	if delay := u.retryDelay(3); delay != 20*time.Second {
+		t.Error("expected 20s, got ", delay)
+	}
+	if delay := u.retryDelay(10); delay != time.Minute {
+		t.Error("expected retry capped at the interval, got ", delay)
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		elapsed  time.Duration
+		expected time.Duration
+	}{
+		{0, debounceDelay},
+		{time.Second, debounceDelay},
+		{debounceMax - debounceDelay, debounceDelay},
+		{debounceMax - time.Second, time.Second},
+		{debounceMax, 0},
+	} {
+		if delay := debounce(start, start.Add(test.elapsed)); delay != test.expected {
+			t.Error("event after ", test.elapsed, ": expected ", test.expected, ", got ", delay)
+		}
+	}
+}
+
+func TestReconcileDryRun(t *testing.T) {
+	provider := &memoryProvider{records: []DNSRecord{
+		{Type: "A", Name: "home.example.com", Content: "198.51.100.1", TTL: 60},
+	}}
+	var output bytes.Buffer
+	u := &updater{
+		records: []*record{newTestRecord(t, provider, nil)},
+		localAddrs: func() ([]localAddr, error) {
+			return []localAddr{testAddr("203.0.113.1", "eth0", 0)}, nil
+		},
+		dryRun: true,
+		output: &output,
+	}
+	changed, err := u.reconcile(context.Background())
+	if err != nil || !changed {
+		t.Fatal("expected planned changes, got ", changed, err)
+	}
+	expected := "home.example.com:\n- A 198.51.100.1 ttl 60\n+ A 203.0.113.1 ttl 60\n"
+	if output.String() != expected {
+		t.Fatalf("expected diff\n%s\ngot\n%s", expected, output.String())
+	}
+	if provider.changes != 0 {
+		t.Fatal("records changed in dry run")
+	}
+	if status := onceStatus(changed, err, true); status != exitChanges {
+		t.Fatal("expected exit status ", exitChanges, ", got ", status)
+	}
+
+	u.dryRun = false
+	changed, err = u.reconcile(context.Background())
+	if err != nil || !changed || provider.changes != 1 {
+		t.Fatal("expected records to be updated, got ", changed, err)
+	}
+	if status := onceStatus(changed, err, false); status != 0 {
+		t.Fatal("expected exit status 0 without dry run, got ", status)
+	}
+	u.dryRun = true
+	output.Reset()
+	changed, err = u.reconcile(context.Background())
+	if err != nil || changed || output.Len() > 0 {
+		t.Fatal("expected no planned changes, got ", output.String(), err)
+	}
+	if status := onceStatus(changed, err, true); status != 0 {
+		t.Fatal("expected exit status 0 without changes, got ", status)
+	}
+}
+
+func TestReconcileFailure(t *testing.T) {
+	failing := &memoryProvider{err: E.New("unavailable")}
+	provider := &memoryProvider{}
+	u := &updater{
+		records: []*record{newTestRecord(t, failing, nil), newTestRecord(t, provider, nil)},
+		localAddrs: func() ([]localAddr, error) {
+			return []localAddr{testAddr("203.0.113.1", "eth0", 0)}, nil
+		},
+	}
+	changed, err := u.reconcile(context.Background())
+	if err == nil {
+		t.Fatal("expected failure")
+	}
+	if !changed || provider.changes != 1 {
+		t.Fatal("expected the other records to be updated")
+	}
+	for _, dryRun := range []bool{false, true} {
+		if status := onceStatus(changed, err, dryRun); status != exitFailure {
+			t.Fatal("expected exit status ", exitFailure, ", got ", status)
+		}
+	}
+}
@@ -7,7 +7,10 @@ import (
 	"os/signal"
 	"syscall"
 
-	"github.com/go-acme/lego/v4/log"
+	"github.com/sagernet/sing-tools/extensions/detour"
+	"github.com/sagernet/sing-tools/extensions/dialer"
+	"github.com/sagernet/sing-tools/extensions/dns"
+	_ "github.com/sagernet/sing-tools/extensions/log"
 	"github.com/sagernet/sing/common"
 	"github.com/sagernet/sing/common/bufio"
 	E "github.com/sagernet/sing/common/exceptions"
@@ -15,13 +18,57 @@ import (
 	N "github.com/sagernet/sing/common/network"
 	"github.com/sagernet/sing/common/redir"
 	"github.com/sagernet/sing/transport/mixed"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	dnsServers     []string
+	domainStrategy string
+	detourURL      string
 )
 
 func main() {
-	server := mixed.NewListener(M.ParseSocksaddr(os.Args[1]).AddrPort(), nil, redir.ModeDisabled, 500, &proxyHandler{})
-	err := server.Start()
+	command := &cobra.Command{
+		Use:  "socks-server address:port",
+		Args: cobra.ExactArgs(1),
+		Run:  run,
+	}
+	command.Flags().StringArrayVar(&dnsServers, "dns", nil, "set dns server, e.g. 1.1.1.1, tcp://1.1.1.1, tls://1.1.1.1, https://1.1.1.1/dns-query")
+	command.Flags().StringVar(&domainStrategy, "domain-strategy", "", "set domain strategy [possible values: prefer_ipv4, prefer_ipv6, ipv4_only, ipv6_only]")
+	command.Flags().StringVar(&detourURL, "detour", "", "forward all traffic through an upstream proxy, e.g. socks5://127.0.0.1:1080, http://127.0.0.1:8080, ss://method:password@127.0.0.1:8388")
+	if err := command.Execute(); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func run(cmd *cobra.Command, args []string) {
+	var options dialer.Options
+	if len(dnsServers) > 0 || domainStrategy != "" {
+		options.DNS = &dns.Options{
+			Servers:  dnsServers,
+			Strategy: domainStrategy,
+		}
+	}
+	outbound, err := dialer.New(options)
 	if err != nil {
-		log.Fatal(err)
+		logrus.Fatal(err)
+	}
+	var detourOptions *detour.Options
+	if detourURL != "" {
+		detourOptions, err = detour.ParseURL(detourURL)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	router, err := detour.NewRouter(outbound, detourOptions)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	server := mixed.NewListener(M.ParseSocksaddr(args[0]).AddrPort(), nil, redir.ModeDisabled, 500, &proxyHandler{router})
+	err = server.Start()
+	if err != nil {
+		logrus.Fatal(err)
 	}
 	osSignals := make(chan os.Signal, 1)
 	signal.Notify(osSignals, os.Interrupt, syscall.SIGTERM)
@@ -29,10 +76,12 @@ func main() {
 	server.Close()
 }
 
-type proxyHandler struct{}
+type proxyHandler struct {
+	router *detour.Router
+}
 
 func (h *proxyHandler) NewConnection(ctx context.Context, conn net.Conn, metadata M.Metadata) error {
-	destConn, err := N.SystemDialer.DialContext(ctx, "tcp", metadata.Destination)
+	destConn, err := h.router.DialContext(ctx, "tcp", metadata.Destination)
 	if err != nil {
 		return err
 	}
@@ -40,7 +89,7 @@ func (h *proxyHandler) NewConnection(ctx context.Context, conn net.Conn, metadat
 }
 
 func (h *proxyHandler) NewPacketConnection(ctx context.Context, conn N.PacketConn, metadata M.Metadata) error {
-	udpConn, err := net.ListenUDP("udp", nil)
+	udpConn, err := h.router.ListenPacket(ctx, metadata.Destination)
 	if err != nil {
 		return err
 	}
@@ -52,5 +101,5 @@ func (h *proxyHandler) HandleError(err error) {
 	if E.IsClosed(err) {
 		return
 	}
-	log.Println(err)
+	logrus.Warn(err)
 }
@@ -3,14 +3,20 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net"
+	"net/http"
 	"net/netip"
 	"os"
 	"os/signal"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"github.com/sagernet/sing-shadowsocks"
 	"github.com/sagernet/sing-shadowsocks/shadowaead_2022"
+	"github.com/sagernet/sing-tools/extensions/dialer"
 	_ "github.com/sagernet/sing-tools/extensions/log"
 	"github.com/sagernet/sing/common"
 	"github.com/sagernet/sing/common/bufio"
@@ -24,20 +30,34 @@ import (
 )
 
 type Flags struct {
-	Server     string        `json:"server"`
-	ServerPort uint16        `json:"server_port"`
-	Bind       string        `json:"local_address"`
-	LocalPort  uint16        `json:"local_port"`
-	Password   string        `json:"password"`
-	Servers    []Destination `json:"servers"`
-	Method     string        `json:"method"`
-	LogLevel   string        `json:"log_level"`
+	Server      string              `json:"server"`
+	ServerPort  uint16              `json:"server_port"`
+	Bind        string              `json:"local_address"`
+	LocalPort   uint16              `json:"local_port"`
+	Password    string              `json:"password"`
+	Servers     []Destination       `json:"servers"`
+	Method      string              `json:"method"`
+	LogLevel    string              `json:"log_level"`
+	HealthCheck *HealthCheckOptions `json:"health_check"`
+	// address of the HTTP status API, e.g. 127.0.0.1:9090
+	StatusListen string `json:"status_listen"`
+	// interval in seconds to log per-upstream statistics, 0 to disable
+	StatsInterval int64 `json:"stats_interval"`
+	dialer.Options
 }
 
 type Destination struct {
+	Server     string     `json:"server"`
+	ServerPort uint16     `json:"server_port"`
+	Password   string     `json:"password"`
+	Upstreams  []Upstream `json:"upstreams"`
+	Strategy   string     `json:"strategy"`
+}
+
+type Upstream struct {
+	Name       string `json:"name"`
 	Server     string `json:"server"`
 	ServerPort uint16 `json:"server_port"`
-	Password   string `json:"password"`
 }
 
 var configPath string
@@ -48,14 +68,19 @@ func main() {
 		Short: "shadowsocks relay",
 		Run:   run,
 	}
-	command.Flags().StringVarP(&configPath, "config", "c", "", "set a configuration file")
+	command.PersistentFlags().StringVarP(&configPath, "config", "c", "", "set a configuration file")
+	command.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "show upstream statistics of the running relay",
+		Run:   status,
+	})
 	err := command.Execute()
 	if err != nil {
 		logrus.Fatal(err)
 	}
 }
 
-func run(cmd *cobra.Command, args []string) {
+func readConfig() *Flags {
 	if configPath == "" {
 		configPath = "config.json"
 	}
@@ -70,6 +95,28 @@ func run(cmd *cobra.Command, args []string) {
 	if err != nil {
 		logrus.Fatal(E.Cause(err, "parse config file"))
 	}
+	return f
+}
+
+func status(cmd *cobra.Command, args []string) {
+	f := readConfig()
+	if f.StatusListen == "" {
+		logrus.Fatal("status_listen is not configured")
+	}
+	upstreams, err := fetchStatus(f.StatusListen)
+	if err != nil {
+		logrus.Fatal(E.Cause(err, "fetch status"))
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAME\tDESTINATION\tALIVE\tSESSIONS\tACTIVE\tUPLOAD\tDOWNLOAD")
+	for _, it := range upstreams {
+		fmt.Fprintf(writer, "%s\t%s\t%t\t%d\t%d\t%d\t%d\n", it.Name, it.Destination, it.Alive, it.Sessions, it.ActiveSessions, it.Upload, it.Download)
+	}
+	writer.Flush()
+}
+
+func run(cmd *cobra.Command, args []string) {
+	f := readConfig()
 
 	if f.LogLevel != "" {
 		level, err := logrus.ParseLevel(f.LogLevel)
@@ -100,7 +147,15 @@ func run(cmd *cobra.Command, args []string) {
 type server struct {
 	tcpIn   *tcp.Listener
 	udpIn   *udp.Listener
+	dialer  *dialer.Dialer
 	service *shadowaead_2022.RelayService[int]
+	groups  []*upstreamGroup
+	checker *healthChecker
+
+	statusListen  string
+	statusServer  *http.Server
+	statsInterval time.Duration
+	done          chan struct{}
 }
 
 func (s *server) Start() error {
@@ -109,17 +164,39 @@ func (s *server) Start() error {
 		return err
 	}
 	err = s.udpIn.Start()
-	return err
+	if err != nil {
+		return err
+	}
+	s.checker.Start()
+	if s.statusListen != "" {
+		err = s.startStatusServer(s.statusListen)
+		if err != nil {
+			return err
+		}
+	}
+	if s.statsInterval > 0 {
+		go s.loopLogStats(s.statsInterval)
+	}
+	return nil
 }
 
 func (s *server) Close() error {
 	s.tcpIn.Close()
 	s.udpIn.Close()
+	s.checker.Close()
+	close(s.done)
+	if s.statusServer != nil {
+		s.statusServer.Close()
+	}
 	return nil
 }
 
 func newServer(f *Flags) (*server, error) {
-	s := new(server)
+	s := &server{
+		statusListen:  f.StatusListen,
+		statsInterval: time.Duration(f.StatsInterval) * time.Second,
+		done:          make(chan struct{}),
+	}
 
 	if f.Server == "" {
 		return nil, E.New("missing server address")
@@ -134,26 +211,34 @@ func newServer(f *Flags) (*server, error) {
 		return nil, err
 	}
 	for i, node := range f.Servers {
-		if node.Server == "" {
-			return nil, E.New("server ", i, " missing address")
-		} else if node.ServerPort == 0 {
-			return nil, E.New("server ", node.Server, " missing port")
-		} else if node.Password == "" {
-			return nil, E.New("server ", node.Server, " missing password")
+		if node.Password == "" {
+			return nil, E.New("server ", i, " missing password")
+		}
+		group, err := newUpstreamGroup(node)
+		if err != nil {
+			return nil, E.Cause(err, "server ", i)
 		}
+		s.groups = append(s.groups, group)
 	}
 	err = service.UpdateUsersWithPasswords(common.MapIndexed(f.Servers, func(index int, it Destination) int {
 		return index
 	}), common.Map(f.Servers, func(it Destination) string {
 		return it.Password
-	}), common.Map(f.Servers, func(it Destination) M.Socksaddr {
-		return M.ParseSocksaddrHostPort(it.Server, it.ServerPort)
+	}), common.Map(s.groups, func(it *upstreamGroup) M.Socksaddr {
+		return it.upstreams[0].destination
 	}))
 	if err != nil {
 		return nil, err
 	}
 	s.service = service
 
+	outbound, err := dialer.New(f.Options)
+	if err != nil {
+		return nil, err
+	}
+	s.dialer = outbound
+	s.checker = newHealthChecker(outbound, s.groups, f.HealthCheck)
+
 	var bind netip.Addr
 	if f.Server != "" {
 		addr, err := netip.ParseAddr(f.Server)
@@ -170,21 +255,42 @@ func newServer(f *Flags) (*server, error) {
 }
 
 func (s *server) NewConnection(ctx context.Context, conn net.Conn, metadata M.Metadata) error {
-	logrus.Info("inbound TCP ", conn.RemoteAddr(), " ==> ", metadata.Destination)
-	destConn, err := N.SystemDialer.DialContext(ctx, "tcp", metadata.Destination)
+	group, err := s.userGroup(ctx)
 	if err != nil {
 		return err
 	}
+	destConn, selected, err := group.DialContext(ctx, s.dialer)
+	if err != nil {
+		return err
+	}
+	logrus.Info("inbound TCP ", conn.RemoteAddr(), " ==> ", selected.name)
+	conn, done := selected.trackConnection(conn)
+	defer done()
 	return bufio.CopyConn(ctx, conn, destConn)
 }
 
 func (s *server) NewPacketConnection(ctx context.Context, conn N.PacketConn, metadata M.Metadata) error {
-	logrus.Info("inbound UDP ", metadata.Source, " ==> ", metadata.Destination)
-	udpConn, err := net.ListenUDP("udp", nil)
+	group, err := s.userGroup(ctx)
 	if err != nil {
 		return err
 	}
-	return bufio.CopyPacketConn(ctx, conn, bufio.NewPacketConn(udpConn))
+	selected := group.Select()
+	logrus.Info("inbound UDP ", metadata.Source, " ==> ", selected.name)
+	udpConn, err := s.dialer.ListenPacket(ctx)
+	if err != nil {
+		return err
+	}
+	conn, done := selected.trackPacketConnection(conn)
+	defer done()
+	return bufio.CopyPacketConn(ctx, &redirectPacketConn{conn, selected.destination}, bufio.NewPacketConn(udpConn))
+}
+
+func (s *server) userGroup(ctx context.Context) (*upstreamGroup, error) {
+	userCtx, isUserCtx := ctx.(*shadowsocks.UserContext[int])
+	if !isUserCtx || userCtx.User < 0 || userCtx.User >= len(s.groups) {
+		return nil, E.New("relay: unknown user")
+	}
+	return s.groups[userCtx.User], nil
 }
 
 func (s *server) HandleError(err error) {
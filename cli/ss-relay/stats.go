@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/sagernet/sing-tools/extensions/user"
+	E "github.com/sagernet/sing/common/exceptions"
+	N "github.com/sagernet/sing/common/network"
+	"github.com/sirupsen/logrus"
+)
+
+type UpstreamStatus struct {
+	Name           string `json:"name"`
+	Destination    string `json:"destination"`
+	Alive          bool   `json:"alive"`
+	Upload         uint64 `json:"upload"`
+	Download       uint64 `json:"download"`
+	Sessions       uint64 `json:"sessions"`
+	ActiveSessions int64  `json:"active_sessions"`
+}
+
+type upstreamStats struct {
+	traffic  user.Traffic
+	sessions uint64
+	active   int64
+	// totals at the last periodic log
+	lastUpload   uint64
+	lastDownload uint64
+	lastSessions uint64
+}
+
+func (u *upstream) trackConnection(conn net.Conn) (net.Conn, func()) {
+	atomic.AddUint64(&u.stats.sessions, 1)
+	atomic.AddInt64(&u.stats.active, 1)
+	return &user.TrackConn{Conn: conn, Traffic: &u.stats.traffic}, func() {
+		atomic.AddInt64(&u.stats.active, -1)
+	}
+}
+
+func (u *upstream) trackPacketConnection(conn N.PacketConn) (N.PacketConn, func()) {
+	atomic.AddUint64(&u.stats.sessions, 1)
+	atomic.AddInt64(&u.stats.active, 1)
+	return &user.TrackPacketConn{PacketConn: conn, Traffic: &u.stats.traffic}, func() {
+		atomic.AddInt64(&u.stats.active, -1)
+	}
+}
+
+func (u *upstream) Status() UpstreamStatus {
+	return UpstreamStatus{
+		Name:           u.name,
+		Destination:    u.destination.String(),
+		Alive:          u.isAlive(),
+		Upload:         atomic.LoadUint64(&u.stats.traffic.Upload),
+		Download:       atomic.LoadUint64(&u.stats.traffic.Download),
+		Sessions:       atomic.LoadUint64(&u.stats.sessions),
+		ActiveSessions: atomic.LoadInt64(&u.stats.active),
+	}
+}
+
+func (s *server) Status() []UpstreamStatus {
+	var status []UpstreamStatus
+	for _, group := range s.groups {
+		for _, it := range group.upstreams {
+			status = append(status, it.Status())
+		}
+	}
+	return status
+}
+
+func (s *server) startStatusServer(listen string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Status())
+	})
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return E.Cause(err, "listen status server")
+	}
+	s.statusServer = &http.Server{Handler: mux}
+	go s.statusServer.Serve(listener)
+	logrus.Info("status server started at ", listener.Addr())
+	return nil
+}
+
+func (s *server) loopLogStats(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.logStats()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *server) logStats() {
+	for _, group := range s.groups {
+		for _, it := range group.upstreams {
+			status := it.Status()
+			upload := status.Upload - it.stats.lastUpload
+			download := status.Download - it.stats.lastDownload
+			sessions := status.Sessions - it.stats.lastSessions
+			it.stats.lastUpload, it.stats.lastDownload, it.stats.lastSessions = status.Upload, status.Download, status.Sessions
+			logrus.Info("upstream ", status.Name, ": ", sessions, " new sessions, ", status.ActiveSessions, " active, ↑ ", upload, " B, ↓ ", download, " B")
+		}
+	}
+}
+
+func fetchStatus(listen string) ([]UpstreamStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+listen+"/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, E.New("HTTP ", response.StatusCode)
+	}
+	var status []UpstreamStatus
+	err = json.NewDecoder(response.Body).Decode(&status)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
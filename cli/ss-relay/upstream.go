@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sagernet/sing-tools/extensions/dialer"
+	"github.com/sagernet/sing/common"
+	"github.com/sagernet/sing/common/buf"
+	E "github.com/sagernet/sing/common/exceptions"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	StrategyFailover   = "failover"
+	StrategyRoundRobin = "round_robin"
+)
+
+type HealthCheckOptions struct {
+	Interval int64 `json:"interval"`
+	Timeout  int64 `json:"timeout"`
+}
+
+type upstream struct {
+	// keep 64-bit counters first for atomic alignment on 32-bit platforms
+	stats       upstreamStats
+	name        string
+	destination M.Socksaddr
+	dead        uint32
+}
+
+func (u *upstream) isAlive() bool {
+	return atomic.LoadUint32(&u.dead) == 0
+}
+
+func (u *upstream) markAlive() {
+	if atomic.CompareAndSwapUint32(&u.dead, 1, 0) {
+		logrus.Info("upstream ", u.name, " is up")
+	}
+}
+
+func (u *upstream) markDead(err error) {
+	if atomic.CompareAndSwapUint32(&u.dead, 0, 1) {
+		logrus.Warn("upstream ", u.name, " is down: ", err)
+	}
+}
+
+type upstreamGroup struct {
+	strategy  string
+	upstreams []*upstream
+	next      uint32
+}
+
+func newUpstreamGroup(node Destination) (*upstreamGroup, error) {
+	group := &upstreamGroup{
+		strategy: node.Strategy,
+	}
+	switch group.strategy {
+	case "":
+		group.strategy = StrategyFailover
+	case StrategyFailover, StrategyRoundRobin:
+	default:
+		return nil, E.New("unknown upstream strategy ", node.Strategy)
+	}
+	upstreams := node.Upstreams
+	if len(upstreams) == 0 {
+		upstreams = []Upstream{{Server: node.Server, ServerPort: node.ServerPort}}
+	}
+	for _, it := range upstreams {
+		if it.Server == "" {
+			return nil, E.New("missing upstream address")
+		} else if it.ServerPort == 0 {
+			return nil, E.New("upstream ", it.Server, " missing port")
+		}
+		destination := M.ParseSocksaddrHostPort(it.Server, it.ServerPort)
+		name := it.Name
+		if name == "" {
+			name = destination.String()
+		}
+		group.upstreams = append(group.upstreams, &upstream{
+			name:        name,
+			destination: destination,
+		})
+	}
+	return group, nil
+}
+
+// candidates returns alive upstreams in the order they should be tried, followed by
+// dead ones as a last resort.
+func (g *upstreamGroup) candidates() []*upstream {
+	ordered := g.upstreams
+	if g.strategy == StrategyRoundRobin && len(ordered) > 1 {
+		start := int(atomic.AddUint32(&g.next, 1)-1) % len(ordered)
+		ordered = append(append([]*upstream{}, ordered[start:]...), ordered[:start]...)
+	}
+	alive := common.Filter(ordered, (*upstream).isAlive)
+	return append(alive, common.Filter(ordered, func(it *upstream) bool {
+		return !it.isAlive()
+	})...)
+}
+
+func (g *upstreamGroup) DialContext(ctx context.Context, dialer *dialer.Dialer) (net.Conn, *upstream, error) {
+	var errors []error
+	for _, candidate := range g.candidates() {
+		conn, err := dialer.DialContext(ctx, "tcp", candidate.destination)
+		if err == nil {
+			candidate.markAlive()
+			return conn, candidate, nil
+		}
+		candidate.markDead(err)
+		errors = append(errors, err)
+	}
+	return nil, nil, E.Cause(common.AnyError(errors...), "all upstreams failed")
+}
+
+func (g *upstreamGroup) Select() *upstream {
+	return g.candidates()[0]
+}
+
+type healthChecker struct {
+	dialer   *dialer.Dialer
+	groups   []*upstreamGroup
+	interval time.Duration
+	timeout  time.Duration
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newHealthChecker(dialer *dialer.Dialer, groups []*upstreamGroup, options *HealthCheckOptions) *healthChecker {
+	c := &healthChecker{
+		dialer:   dialer,
+		groups:   groups,
+		interval: 30 * time.Second,
+		timeout:  5 * time.Second,
+		done:     make(chan struct{}),
+	}
+	if options != nil {
+		if options.Interval > 0 {
+			c.interval = time.Duration(options.Interval) * time.Second
+		}
+		if options.Timeout > 0 {
+			c.timeout = time.Duration(options.Timeout) * time.Second
+		}
+	}
+	return c
+}
+
+func (c *healthChecker) Start() {
+	c.wg.Add(1)
+	go c.loop()
+}
+
+func (c *healthChecker) Close() error {
+	close(c.done)
+	c.wg.Wait()
+	return nil
+}
+
+func (c *healthChecker) loop() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.checkAll()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *healthChecker) checkAll() {
+	var wg sync.WaitGroup
+	for _, group := range c.groups {
+		for _, it := range group.upstreams {
+			wg.Add(1)
+			go func(it *upstream) {
+				defer wg.Done()
+				c.check(it)
+			}(it)
+		}
+	}
+	wg.Wait()
+}
+
+func (c *healthChecker) check(it *upstream) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	conn, err := c.dialer.DialContext(ctx, "tcp", it.destination)
+	if err != nil {
+		it.markDead(err)
+		return
+	}
+	conn.Close()
+	it.markAlive()
+}
+
+// redirectPacketConn sends every packet of a relay session to the selected upstream.
+type redirectPacketConn struct {
+	N.PacketConn
+	destination M.Socksaddr
+}
+
+func (c *redirectPacketConn) ReadPacket(buffer *buf.Buffer) (M.Socksaddr, error) {
+	_, err := c.PacketConn.ReadPacket(buffer)
+	return c.destination, err
+}
+
+func (c *redirectPacketConn) Upstream() any {
+	return c.PacketConn
+}
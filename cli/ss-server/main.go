@@ -13,6 +13,8 @@ import (
 	"github.com/sagernet/sing-shadowsocks"
 	"github.com/sagernet/sing-shadowsocks/shadowaead"
 	"github.com/sagernet/sing-shadowsocks/shadowaead_2022"
+	"github.com/sagernet/sing-tools/extensions/detour"
+	"github.com/sagernet/sing-tools/extensions/dialer"
 	_ "github.com/sagernet/sing-tools/extensions/log"
 	"github.com/sagernet/sing/common"
 	"github.com/sagernet/sing/common/bufio"
@@ -33,9 +35,11 @@ type Flags struct {
 	LocalPort  uint16 `json:"local_port"`
 	Password   string `json:"password"`
 	// deprecated
-	Key      string `json:"key"`
-	Method   string `json:"method"`
-	LogLevel string `json:"log_level"`
+	Key      string          `json:"key"`
+	Method   string          `json:"method"`
+	LogLevel string          `json:"log_level"`
+	Detour   *detour.Options `json:"detour"`
+	dialer.Options
 }
 
 var configPath string
@@ -98,6 +102,7 @@ func run(cmd *cobra.Command, args []string) {
 type server struct {
 	tcpIn   *tcp.Listener
 	udpIn   *udp.Listener
+	router  *detour.Router
 	service shadowsocks.Service
 }
 
@@ -149,6 +154,15 @@ func newServer(f *Flags) (*server, error) {
 		return nil, E.New("unsupported method " + f.Method)
 	}
 
+	outbound, err := dialer.New(f.Options)
+	if err != nil {
+		return nil, err
+	}
+	s.router, err = detour.NewRouter(outbound, f.Detour)
+	if err != nil {
+		return nil, err
+	}
+
 	var bind netip.Addr
 	if f.Server != "" {
 		addr, err := netip.ParseAddr(f.Server)
@@ -168,7 +182,7 @@ func (s *server) NewConnection(ctx context.Context, conn net.Conn, metadata M.Me
 	if metadata.Destination.Fqdn == uot.UOTMagicAddress {
 		logrus.Info("inbound UOT from ", conn.RemoteAddr())
 
-		udpConn, err := net.ListenUDP("udp", nil)
+		udpConn, err := s.router.ListenPacket(ctx, M.Socksaddr{})
 		if err != nil {
 			return err
 		}
@@ -176,7 +190,7 @@ func (s *server) NewConnection(ctx context.Context, conn net.Conn, metadata M.Me
 	}
 
 	logrus.Info("inbound TCP ", conn.RemoteAddr(), " ==> ", metadata.Destination)
-	destConn, err := N.SystemDialer.DialContext(ctx, "tcp", metadata.Destination)
+	destConn, err := s.router.DialContext(ctx, "tcp", metadata.Destination)
 	if err != nil {
 		return err
 	}
@@ -185,7 +199,7 @@ func (s *server) NewConnection(ctx context.Context, conn net.Conn, metadata M.Me
 
 func (s *server) NewPacketConnection(ctx context.Context, conn N.PacketConn, metadata M.Metadata) error {
 	logrus.Info("inbound UDP ", metadata.Source, " ==> ", metadata.Destination)
-	udpConn, err := net.ListenUDP("udp", nil)
+	udpConn, err := s.router.ListenPacket(ctx, metadata.Destination)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,221 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/registration"
+	E "github.com/sagernet/sing/common/exceptions"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+type AccountInfo struct {
+	Email     string
+	URI       string
+	Status    string
+	Directory string
+	KeyType   certcrypto.KeyType
+	// Thumbprint is the base64url SHA-256 JWK thumbprint of the account key.
+	Thumbprint string
+}
+
+// Account returns the stored account for the configured directory, without contacting
+// the ACME server.
+func (c *CertificateManager) Account() (*AccountInfo, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	account, accountKey, err := c.loadAccount()
+	if err != nil {
+		return nil, err
+	}
+	thumbprint, err := (&jose.JSONWebKey{Key: publicKey(accountKey)}).Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	info := &AccountInfo{
+		Email:      c.email,
+		URI:        account.URI,
+		Status:     account.Body.Status,
+		Directory:  c.directoryURL,
+		KeyType:    keyTypeOf(accountKey),
+		Thumbprint: base64.RawURLEncoding.EncodeToString(thumbprint),
+	}
+	if len(account.Body.Contact) > 0 {
+		info.Email = strings.TrimPrefix(account.Body.Contact[0], "mailto:")
+	}
+	return info, nil
+}
+
+func (c *CertificateManager) loadAccount() (*registration.Resource, crypto.PrivateKey, error) {
+	accountName := c.accountName()
+	if !c.storage.Exists(accountName + ".json") {
+		return nil, nil, E.New("acme: no account registered at ", c.directoryURL)
+	}
+	var account registration.Resource
+	err := loadJSON(c.storage, accountName+".json", &account)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := c.storage.Load(accountName + ".key")
+	if err != nil {
+		return nil, nil, err
+	}
+	accountKey, err := parsePrivateKey(content)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &account, accountKey, nil
+}
+
+// RotateAccountKey replaces the account key by a new key of keyType (RFC 8555 section 7.3.5),
+// which lego does not implement.
+func (c *CertificateManager) RotateAccountKey(keyType certcrypto.KeyType) error {
+	if c.err != nil {
+		return c.err
+	}
+	unlock, err := c.storage.Lock(c.accountName())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	account, oldKey, err := c.loadAccount()
+	if err != nil {
+		return err
+	}
+	newKey, err := certcrypto.GeneratePrivateKey(keyType)
+	if err != nil {
+		return err
+	}
+	client, err := c.httpClient()
+	if err != nil {
+		return err
+	}
+	var directory struct {
+		NewNonce  string `json:"newNonce"`
+		KeyChange string `json:"keyChange"`
+	}
+	err = getJSON(client, c.directoryURL, &directory)
+	if err != nil {
+		return E.Cause(err, "acme: get directory")
+	}
+	if directory.KeyChange == "" {
+		return E.New("acme: key change not supported by ", c.directoryURL)
+	}
+
+	innerPayload, err := json.Marshal(map[string]any{
+		"account": account.URI,
+		"oldKey":  jose.JSONWebKey{Key: publicKey(oldKey)},
+	})
+	if err != nil {
+		return err
+	}
+	inner, err := signJWS(newKey, "", nil, directory.KeyChange, innerPayload)
+	if err != nil {
+		return err
+	}
+	outer, err := signJWS(oldKey, account.URI, &nonceSource{client, directory.NewNonce}, directory.KeyChange, []byte(inner))
+	if err != nil {
+		return err
+	}
+	response, err := client.Post(directory.KeyChange, "application/jose+json", strings.NewReader(outer))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		content, _ := ioutil.ReadAll(response.Body)
+		return E.New("acme: key change: HTTP ", response.StatusCode, ": ", string(content))
+	}
+
+	content, err := encodePrivateKey(newKey)
+	if err != nil {
+		return err
+	}
+	return c.storage.Store(c.accountName()+".key", content)
+}
+
+func publicKey(privateKey crypto.PrivateKey) crypto.PublicKey {
+	switch key := privateKey.(type) {
+	case *ecdsa.PrivateKey:
+		return key.Public()
+	case *rsa.PrivateKey:
+		return key.Public()
+	}
+	return nil
+}
+
+// signJWS signs payload with the embedded public key, or the account kid if set.
+func signJWS(privateKey crypto.PrivateKey, kid string, nonce jose.NonceSource, url string, payload []byte) (string, error) {
+	var algorithm jose.SignatureAlgorithm
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		algorithm = jose.RS256
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			algorithm = jose.ES256
+		case elliptic.P384():
+			algorithm = jose.ES384
+		}
+	}
+	if algorithm == "" {
+		return "", E.New("acme: unsupported account key")
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: algorithm,
+		Key:       jose.JSONWebKey{Key: privateKey, KeyID: kid},
+	}, &jose.SignerOptions{
+		EmbedJWK:    kid == "",
+		NonceSource: nonce,
+		ExtraHeaders: map[jose.HeaderKey]any{
+			"url": url,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	return signed.FullSerialize(), nil
+}
+
+type nonceSource struct {
+	client *http.Client
+	url    string
+}
+
+func (s *nonceSource) Nonce() (string, error) {
+	response, err := s.client.Head(s.url)
+	if err != nil {
+		return "", err
+	}
+	response.Body.Close()
+	nonce := response.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", E.New("acme: missing nonce")
+	}
+	return nonce, nil
+}
+
+func getJSON(client *http.Client, url string, value any) error {
+	response, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return E.New("HTTP ", response.StatusCode)
+	}
+	return json.NewDecoder(response.Body).Decode(value)
+}
@@ -1,94 +1,261 @@
 package acme
 
 import (
+	"bytes"
 	"crypto"
-	"crypto/rand"
-	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
-	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
-	"time"
 
 	"github.com/go-acme/lego/v4/certcrypto"
 	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge/dns01"
 	"github.com/go-acme/lego/v4/lego"
 	legoLog "github.com/go-acme/lego/v4/log"
 	"github.com/go-acme/lego/v4/registration"
 	"github.com/sagernet/sing-tools/extensions/log"
+	"github.com/sagernet/sing/common"
 	E "github.com/sagernet/sing/common/exceptions"
-	"github.com/sagernet/sing/common/rw"
 	"github.com/sagernet/sing/common/x/list"
 )
 
+var logger = log.NewLogger("acme")
+
 func init() {
-	legoLog.Logger = log.NewLogger("acme")
+	legoLog.Logger = logger
 }
 
 type CertificateUpdateListener func(certificate *tls.Certificate)
 
 type CertificateManager struct {
-	email    string
-	path     string
-	provider string
-
-	access     sync.Mutex
-	callbacks  map[string]*list.List[CertificateUpdateListener]
-	renewClose chan struct{}
+	email           string
+	storage         Storage
+	provider        string
+	dnsCredentials  Credentials
+	directoryURL    string
+	externalAccount *ExternalAccountSettings
+	rootCA          string
+	keyType         certcrypto.KeyType
+	accountKeyType  certcrypto.KeyType
+	dualCertificate bool
+	disableOCSP     bool
+	renewFraction   float64
+	clock           clock
+
+	httpChallenge    *httpChallengeProvider
+	tlsALPNChallenge *tlsALPNChallengeProvider
+
+	access       sync.Mutex
+	callbacks    map[string]*list.List[CertificateUpdateListener]
+	domains      map[string][]string
+	managed      map[string]bool
+	names        map[string]string
+	certificates map[string][]*tls.Certificate
+	renewals     map[string]*renewal
+	renewWake    chan struct{}
+	renewClose   chan struct{}
+	renewDone    chan struct{}
+	renewStopped chan struct{}
+
+	renewalInfoEndpoint string
+	renewalInfoLoaded   bool
+
+	// err reports invalid settings passed to NewCertificateManager
+	err error
 }
 
+// NewCertificateManager is like CreateCertificateManager, but invalid settings are
+// returned as error by every request of the manager instead.
 func NewCertificateManager(settings *Settings) *CertificateManager {
-	m := &CertificateManager{
-		email:     settings.Email,
-		path:      settings.DataDirectory,
-		provider:  settings.DNSProvider,
-		callbacks: make(map[string]*list.List[CertificateUpdateListener]),
-	}
-	if m.path == "" {
-		m.path = "acme"
+	m, err := CreateCertificateManager(settings)
+	if err != nil {
+		m = newCertificateManager(NewMemoryStorage())
+		m.err = err
 	}
 	return m
 }
 
-func (c *CertificateManager) GetKeyPair(domain string) (*tls.Certificate, error) {
-	if domain == "" {
-		return nil, E.New("acme: empty domain name")
+// CreateCertificateManager returns a manager keeping its data in the configured
+// data directory, or an error if the settings are invalid.
+func CreateCertificateManager(settings *Settings) (*CertificateManager, error) {
+	path := settings.DataDirectory
+	if path == "" {
+		path = "acme"
 	}
+	storage, err := NewStorage(path, settings.Storage)
+	if err != nil {
+		return nil, err
+	}
+	return NewCertificateManagerWithStorage(settings, storage)
+}
 
-	dnsProvider, err := NewDNSChallengeProviderByName(c.provider)
+// NewCertificateManagerWithStorage is like CreateCertificateManager, but keeps everything
+// in storage instead of the configured data directory.
+func NewCertificateManagerWithStorage(settings *Settings, storage Storage) (*CertificateManager, error) {
+	keyType, err := ParseKeyType(settings.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	accountKeyType, err := ParseKeyType(settings.AccountKeyType)
+	if err != nil {
+		return nil, err
+	}
+	dnsCredentials, err := settings.dnsCredentials()
 	if err != nil {
 		return nil, err
 	}
+	m := newCertificateManager(storage)
+	m.email = settings.Email
+	m.provider = settings.DNSProvider
+	m.dnsCredentials = dnsCredentials
+	m.directoryURL = directoryURL(settings.DirectoryURL)
+	m.externalAccount = settings.ExternalAccount
+	m.rootCA = settings.RootCA
+	m.keyType = keyType
+	m.accountKeyType = accountKeyType
+	m.dualCertificate = settings.DualCertificate
+	m.disableOCSP = settings.DisableOCSPStapling
+	m.renewFraction = settings.RenewFraction
+	if m.renewFraction == 0 {
+		m.renewFraction = DefaultRenewFraction
+	} else if m.renewFraction < 0 || m.renewFraction >= 1 {
+		return nil, E.New("acme: renew fraction must be between 0 and 1")
+	}
+	if settings.HTTPChallenge != nil {
+		m.httpChallenge = newHTTPChallengeProvider(settings.HTTPChallenge.Listen)
+	}
+	if settings.TLSALPNChallenge != nil {
+		m.tlsALPNChallenge = newTLSALPNChallengeProvider(settings.TLSALPNChallenge.Listen)
+	}
+	return m, nil
+}
 
-	accountPath := c.path + "/account.json"
-	accountKeyPath := c.path + "/account.key"
+func newCertificateManager(storage Storage) *CertificateManager {
+	return &CertificateManager{
+		storage:      storage,
+		clock:        systemClock{},
+		callbacks:    make(map[string]*list.List[CertificateUpdateListener]),
+		domains:      make(map[string][]string),
+		managed:      make(map[string]bool),
+		names:        make(map[string]string),
+		certificates: make(map[string][]*tls.Certificate),
+		renewals:     make(map[string]*renewal),
+		renewWake:    make(chan struct{}, 1),
+	}
+}
+
+// HTTPChallengeHandler serves pending HTTP-01 challenges and passes other requests to next,
+// so that an existing HTTP server on port 80 can answer them.
+func (c *CertificateManager) HTTPChallengeHandler(next http.Handler) http.Handler {
+	if next == nil {
+		next = http.NotFoundHandler()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.httpChallenge != nil {
+			if _, loaded := c.httpChallenge.keyAuth(r.URL.Path); loaded {
+				c.httpChallenge.ServeHTTP(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-	privateKeyPath := c.path + "/" + domain + ".key"
-	certificatePath := c.path + "/" + domain + ".crt"
-	requestPath := c.path + "/" + domain + ".json"
+// GetChallengeCertificate returns the TLS-ALPN-01 challenge certificate for hello, or nil
+// if it is not a challenge handshake. It is meant to be called first from tls.Config.GetCertificate,
+// and the tls.Config must list "acme-tls/1" in NextProtos.
+func (c *CertificateManager) GetChallengeCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if c.tlsALPNChallenge == nil {
+		return nil, nil
+	}
+	return c.tlsALPNChallenge.GetCertificate(hello)
+}
 
-	if !rw.FileExists(accountKeyPath) {
-		err = writeNewPrivateKey(accountKeyPath)
+func (c *CertificateManager) setupChallenges(client *lego.Client) error {
+	var configured bool
+	if c.provider != "" {
+		dnsProvider, err := NewDNSChallengeProviderWithCredentials(c.provider, c.dnsCredentials)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		var options []dns01.ChallengeOption
+		if preChecker, isPreChecker := dnsProvider.(dnsPreChecker); isPreChecker {
+			options = append(options, dns01.WrapPreCheck(preChecker.PreCheck))
 		}
+		err = client.Challenge.SetDNS01Provider(dnsProvider, options...)
+		if err != nil {
+			return err
+		}
+		configured = true
+	}
+	if c.httpChallenge != nil {
+		err := client.Challenge.SetHTTP01Provider(c.httpChallenge)
+		if err != nil {
+			return err
+		}
+		configured = true
+	}
+	if c.tlsALPNChallenge != nil {
+		err := client.Challenge.SetTLSALPN01Provider(c.tlsALPNChallenge)
+		if err != nil {
+			return err
+		}
+		configured = true
+	}
+	if !configured {
+		return E.New("acme: no challenge configured")
 	}
+	return nil
+}
+
+func (c *CertificateManager) newClient() (*lego.Client, error) {
+	accountName := c.accountName()
+	accountPath := accountName + ".json"
+	accountKeyPath := accountName + ".key"
 
-	accountKey, err := readPrivateKey(accountKeyPath)
+	unlock, err := c.storage.Lock(accountName)
 	if err != nil {
 		return nil, err
 	}
+	defer unlock()
+
+	var accountKey crypto.PrivateKey
+	if c.storage.Exists(accountKeyPath) {
+		content, err := c.storage.Load(accountKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		accountKey, err = parsePrivateKey(content)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		accountKey, err = certcrypto.GeneratePrivateKey(c.accountKeyType)
+		if err != nil {
+			return nil, err
+		}
+		content, err := encodePrivateKey(accountKey)
+		if err != nil {
+			return nil, err
+		}
+		err = c.storage.Store(accountKeyPath, content)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	user := &acmeUser{
 		email:      c.email,
 		privateKey: accountKey,
 	}
 
-	if rw.FileExists(accountPath) {
+	if c.storage.Exists(accountPath) {
 		var account registration.Resource
-		err = rw.ReadJSON(accountPath, &account)
+		err = loadJSON(c.storage, accountPath, &account)
 		if err != nil {
 			return nil, err
 		}
@@ -96,153 +263,345 @@ func (c *CertificateManager) GetKeyPair(domain string) (*tls.Certificate, error)
 	}
 
 	config := lego.NewConfig(user)
-	config.Certificate.KeyType = certcrypto.RSA4096
+	config.CADirURL = c.directoryURL
+	config.Certificate.KeyType = c.keyType
+	if c.rootCA != "" {
+		httpClient, err := newHTTPClient(c.rootCA)
+		if err != nil {
+			return nil, err
+		}
+		config.HTTPClient = httpClient
+	}
 
 	client, err := lego.NewClient(config)
 	if err != nil {
 		return nil, err
 	}
 
-	err = client.Challenge.SetDNS01Provider(dnsProvider)
+	err = c.setupChallenges(client)
 	if err != nil {
 		return nil, err
 	}
 
 	if user.GetRegistration() == nil {
-		account, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+		var account *registration.Resource
+		if c.externalAccount != nil {
+			account, err = client.Registration.RegisterWithExternalAccountBinding(registration.RegisterEABOptions{
+				TermsOfServiceAgreed: true,
+				Kid:                  c.externalAccount.KeyID,
+				HmacEncoded:          c.externalAccount.MACKey,
+			})
+		} else if client.GetExternalAccountRequired() {
+			return nil, E.New("acme: external account binding is required by ", c.directoryURL)
+		} else {
+			account, err = client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+		}
 		if err != nil {
 			return nil, err
 		}
 		user.registration = account
-		err = rw.WriteJSON(accountPath, account)
+		err = storeJSON(c.storage, accountPath, account)
 		if err != nil {
 			return nil, err
 		}
 	}
+	return client, nil
+}
 
-	var renew bool
-	keyPair, err := tls.LoadX509KeyPair(certificatePath, privateKeyPath)
-	if err == nil {
-		cert, err := x509.ParseCertificate(keyPair.Certificate[0])
-		if err == nil {
-			keyPair.Leaf = cert
-			expiresDays := time.Until(cert.NotAfter).Hours() / 24
-			if expiresDays > 15 {
-				return &keyPair, nil
-			} else {
-				renew = true
-			}
-		}
+// accountName keeps the original file names for Let's Encrypt, accounts for other
+// directories are stored separately since they are not interchangeable.
+func (c *CertificateManager) accountName() string {
+	if c.directoryURL == lego.LEDirectoryProduction {
+		return "account"
+	}
+	directoryURL, err := url.Parse(c.directoryURL)
+	if err != nil || directoryURL.Host == "" {
+		return "account"
 	}
+	return "account-" + strings.ReplaceAll(directoryURL.Host, ":", "_")
+}
 
-	if renew && rw.FileExists(requestPath) {
-		var request Certificate
-		err = rw.ReadJSON(requestPath, &request)
-		if err != nil {
-			return nil, err
+func (c *CertificateManager) GetKeyPair(domain string) (*tls.Certificate, error) {
+	return c.GetKeyPairForDomains([]string{domain})
+}
+
+// GetKeyPairForDomains obtains or loads one certificate covering all domains, which may
+// include wildcards like *.example.com (those require the DNS-01 challenge).
+func (c *CertificateManager) GetKeyPairForDomains(domains []string) (*tls.Certificate, error) {
+	keyPairs, err := c.GetKeyPairsForDomains(domains)
+	if err != nil {
+		return nil, err
+	}
+	return keyPairs[0], nil
+}
+
+// GetKeyPairsForDomains is like GetKeyPairForDomains, but with dual certificates enabled
+// it also returns the certificate of the alternative key type, see ChooseCertificate.
+func (c *CertificateManager) GetKeyPairsForDomains(domains []string) ([]*tls.Certificate, error) {
+	return c.getKeyPairs(domains, false)
+}
+
+// getKeyPairs loads the certificates for domains, renewing them if they are close to
+// expiry or, with force set, if they were not replaced since they were last loaded.
+func (c *CertificateManager) getKeyPairs(domains []string, force bool) ([]*tls.Certificate, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	domains, err := normalizeDomains(domains)
+	if err != nil {
+		return nil, err
+	}
+	if c.provider == "" && common.Any(domains, isWildcard) {
+		return nil, E.New("acme: wildcard certificates require a dns provider")
+	}
+
+	// the client registers the account, only create it when a certificate is requested
+	var client *lego.Client
+	getClient := func() (*lego.Client, error) {
+		if client == nil {
+			client, err = c.newClient()
 		}
-		newCert, err := client.Certificate.Renew((certificate.Resource)(request), true, false, "")
-		if err != nil {
-			return nil, err
+		return client, err
+	}
+
+	key := StorageKey(domains)
+	var current []*tls.Certificate
+	if force {
+		c.access.Lock()
+		current = c.certificates[key]
+		c.access.Unlock()
+	}
+
+	var keyPairs []*tls.Certificate
+	for index, it := range c.certificateNames(key) {
+		var replaced *x509.Certificate
+		if index < len(current) {
+			replaced = current[index].Leaf
+		} else if force {
+			if keyPair, _ := c.loadKeyPair(it.name, it.keyType, nil); keyPair != nil {
+				replaced = keyPair.Leaf
+			}
 		}
-		err = rw.WriteJSON(requestPath, (*Certificate)(newCert))
+		keyPair, err := c.loadOrObtain(getClient, domains, it.name, it.keyType, replaced)
 		if err != nil {
 			return nil, err
 		}
-		certResponse, err := http.Get(newCert.CertURL)
-		if err != nil {
-			return nil, err
+		keyPair, revoked := c.stapleOCSP(it.name, keyPair)
+		if revoked {
+			// obtain a new certificate with a new key
+			logger.Error("certificate ", it.name, " is revoked, obtaining a new one")
+			err = c.deleteRevoked(it.name, keyPair.Leaf)
+			if err != nil {
+				return nil, err
+			}
+			keyPair, err = c.loadOrObtain(getClient, domains, it.name, it.keyType, nil)
+			if err != nil {
+				return nil, err
+			}
+			keyPair, _ = c.stapleOCSP(it.name, keyPair)
 		}
-		defer certResponse.Body.Close()
-		content, err := ioutil.ReadAll(certResponse.Body)
-		if err != nil {
-			return nil, err
+		keyPairs = append(keyPairs, keyPair)
+	}
+
+	c.update(key, domains, keyPairs)
+	return keyPairs, nil
+}
+
+// update replaces the certificates of key and notifies listeners.
+func (c *CertificateManager) update(key string, domains []string, keyPairs []*tls.Certificate) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	c.certificates[key] = keyPairs
+	c.schedule(key, keyPairs)
+	for _, domain := range domains {
+		c.names[domain] = key
+	}
+	listeners := c.callbacks[key]
+	if listeners != nil {
+		for listener := listeners.Front(); listener != nil; listener = listener.Next() {
+			listener.Value(keyPairs[0])
 		}
-		if certResponse.StatusCode != 200 {
-			return nil, E.New("HTTP ", certResponse.StatusCode, ": ", string(content))
+	}
+}
+
+type certificateName struct {
+	name    string
+	keyType certcrypto.KeyType
+}
+
+// certificateNames returns the storage names of the certificates of key, the second
+// one holds the alternative key type in dual mode.
+func (c *CertificateManager) certificateNames(key string) []certificateName {
+	names := []certificateName{{key, c.keyType}}
+	if c.dualCertificate {
+		alternativeType := alternativeKeyType(c.keyType)
+		suffix := ".rsa"
+		if isECKeyType(alternativeType) {
+			suffix = ".ecdsa"
 		}
-		err = rw.WriteFile(certificatePath, content)
+		names = append(names, certificateName{key + suffix, alternativeType})
+	}
+	return names
+}
+
+// loadOrObtain returns the stored certificate unless it is close to expiry or still
+// the replaced one, otherwise it renews or obtains it.
+func (c *CertificateManager) loadOrObtain(getClient func() (*lego.Client, error), domains []string, name string, keyType certcrypto.KeyType, replaced *x509.Certificate) (*tls.Certificate, error) {
+	keyPair, renew := c.loadKeyPair(name, keyType, replaced)
+	if keyPair != nil && !renew {
+		return keyPair, nil
+	}
+
+	// another process sharing the storage may be obtaining the same certificate
+	unlock, err := c.storage.Lock(name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	keyPair, renew = c.loadKeyPair(name, keyType, replaced)
+	if keyPair != nil && !renew {
+		return keyPair, nil
+	}
+
+	client, err := getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	privateKeyPath := name + ".key"
+	certificatePath := name + ".crt"
+	requestPath := name + ".json"
+
+	var resource *certificate.Resource
+	if renew && c.storage.Exists(requestPath) {
+		var request Certificate
+		err = loadJSON(c.storage, requestPath, &request)
 		if err != nil {
 			return nil, err
 		}
-
-		keyPair, err = tls.LoadX509KeyPair(certificatePath, privateKeyPath)
+		resource, err = client.Certificate.Renew((certificate.Resource)(request), true, false, "")
 		if err != nil {
 			return nil, err
 		}
-
-		goto finish
-	}
-
-	if !rw.FileExists(certificatePath) {
-		if !rw.FileExists(privateKeyPath) {
-			err = writeNewPrivateKey(privateKeyPath)
+	} else {
+		// reuse the existing key unless the configured key type changed, and only
+		// replace it once the new certificate is issued
+		var privateKey crypto.PrivateKey
+		if c.storage.Exists(privateKeyPath) {
+			content, err := c.storage.Load(privateKeyPath)
+			if err != nil {
+				return nil, err
+			}
+			privateKey, err = parsePrivateKey(content)
 			if err != nil {
 				return nil, err
 			}
 		}
-
-		privateKey, err := readPrivateKey(privateKeyPath)
-		if err != nil {
-			return nil, err
+		if privateKey == nil || keyTypeOf(privateKey) != keyType {
+			privateKey, err = certcrypto.GeneratePrivateKey(keyType)
+			if err != nil {
+				return nil, err
+			}
 		}
 
 		request := certificate.ObtainRequest{
-			Domains:    []string{domain},
+			Domains:    domains,
 			Bundle:     true,
 			PrivateKey: privateKey,
 		}
-		certificates, err := client.Certificate.Obtain(request)
-		if err != nil {
-			return nil, err
-		}
-		err = rw.WriteJSON(requestPath, (*Certificate)(certificates))
-		if err != nil {
-			return nil, err
-		}
-		certResponse, err := http.Get(certificates.CertURL)
+		resource, err = client.Certificate.Obtain(request)
 		if err != nil {
 			return nil, err
 		}
-		defer certResponse.Body.Close()
-		content, err := ioutil.ReadAll(certResponse.Body)
+		content, err := encodePrivateKey(privateKey)
 		if err != nil {
 			return nil, err
 		}
-		if certResponse.StatusCode != 200 {
-			return nil, E.New("HTTP ", certResponse.StatusCode, ": ", string(content))
-		}
-		err = rw.WriteFile(certificatePath, content)
+		err = c.storage.Store(privateKeyPath, content)
 		if err != nil {
 			return nil, err
 		}
 	}
-
-finish:
-	keyPair, err = tls.LoadX509KeyPair(certificatePath, privateKeyPath)
+	err = storeJSON(c.storage, requestPath, (*Certificate)(resource))
+	if err != nil {
+		return nil, err
+	}
+	err = c.storage.Store(certificatePath, resource.Certificate)
 	if err != nil {
 		return nil, err
 	}
 
-	c.access.Lock()
-	listeners := c.callbacks[domain]
-	if listeners != nil {
-		for listener := listeners.Front(); listener != nil; listener = listener.Next() {
-			listener.Value(&keyPair)
+	keyPair, _ = c.loadKeyPair(name, keyType, nil)
+	if keyPair == nil {
+		return nil, E.New("acme: load issued certificate ", name)
+	}
+	return keyPair, nil
+}
+
+// deleteRevoked deletes the stored certificate and key of name unless another process
+// sharing the storage replaced the revoked leaf already.
+func (c *CertificateManager) deleteRevoked(name string, leaf *x509.Certificate) error {
+	unlock, err := c.storage.Lock(name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if content, err := c.storage.Load(name + ".crt"); err == nil {
+		if block, _ := pem.Decode(content); block != nil && !bytes.Equal(block.Bytes, leaf.Raw) {
+			return nil
 		}
 	}
-	c.access.Unlock()
+	for _, extension := range certificateExtensions {
+		err = c.storage.Delete(name + extension)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	return &keyPair, nil
+// loadKeyPair returns the stored certificate and whether it should be renewed, or nil if
+// it is missing, unreadable or of another key type.
+func (c *CertificateManager) loadKeyPair(name string, keyType certcrypto.KeyType, replaced *x509.Certificate) (*tls.Certificate, bool) {
+	certificateContent, err := c.storage.Load(name + ".crt")
+	if err != nil {
+		return nil, false
+	}
+	privateKeyContent, err := c.storage.Load(name + ".key")
+	if err != nil {
+		return nil, false
+	}
+	keyPair, err := tls.X509KeyPair(certificateContent, privateKeyContent)
+	if err != nil || keyTypeOf(keyPair.PrivateKey) != keyType {
+		return nil, false
+	}
+	keyPair.Leaf, err = x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, false
+	}
+	renew := !c.clock.Now().Before(c.renewTime(keyPair.Leaf, false))
+	if replaced != nil && keyPair.Leaf.Equal(replaced) {
+		renew = true
+	}
+	return &keyPair, renew
 }
 
 func (c *CertificateManager) RegisterUpdateListener(domain string, listener CertificateUpdateListener) *list.Element[CertificateUpdateListener] {
+	return c.RegisterDomainsUpdateListener([]string{domain}, listener)
+}
+
+// RegisterDomainsUpdateListener registers listener for the certificate covering domains,
+// as obtained by GetKeyPairForDomains.
+func (c *CertificateManager) RegisterDomainsUpdateListener(domains []string, listener CertificateUpdateListener) *list.Element[CertificateUpdateListener] {
+	domains = sortDomains(domains)
+	key := StorageKey(domains)
 	c.access.Lock()
 	defer c.access.Unlock()
-	listeners := c.callbacks[domain]
-	if listeners != nil {
+	listeners := c.callbacks[key]
+	if listeners == nil {
 		listeners = new(list.List[CertificateUpdateListener])
-		c.callbacks[domain] = listeners
+		c.callbacks[key] = listeners
+		c.domains[key] = domains
 	}
 	element := listeners.PushBack(listener)
 	if c.renewClose == nil {
@@ -254,40 +613,24 @@ func (c *CertificateManager) RegisterUpdateListener(domain string, listener Cert
 func (c *CertificateManager) UnregisterUpdateListener(element *list.Element[CertificateUpdateListener]) {
 	c.access.Lock()
 	defer c.access.Unlock()
-	element.List().Remove(element)
-	for _, listeners := range c.callbacks {
-		if listeners.Len() > 0 {
-			return
-		}
-	}
-	renewClose := c.renewClose
-	if renewClose != nil {
-		close(renewClose)
-		c.renewClose = nil
+	listeners := element.List()
+	listeners.Remove(element)
+	if listeners.Len() > 0 {
+		return
 	}
-}
-
-func (c *CertificateManager) start() {
-	renew := time.NewTicker(time.Hour * 24)
-	defer renew.Stop()
-	renewClose := make(chan struct{})
-	c.renewClose = renewClose
-	go func() {
-		select {
-		case <-renew.C:
-			c.access.Lock()
-			domains := make([]string, 0, len(c.callbacks))
-			for domain := range c.callbacks {
-				domains = append(domains, domain)
-			}
-			c.access.Unlock()
-			for _, domain := range domains {
-				_, _ = c.GetKeyPair(domain)
+	for key, it := range c.callbacks {
+		if it == listeners {
+			delete(c.callbacks, key)
+			if !c.managed[key] {
+				delete(c.domains, key)
 			}
-		case <-renewClose:
-			return
 		}
-	}()
+	}
+	if len(c.domains) > 0 {
+		return
+	}
+	// the loop may be renewing and waiting for access, do not wait for it here
+	c.stop()
 }
 
 type acmeUser struct {
@@ -308,31 +651,6 @@ func (u *acmeUser) GetPrivateKey() crypto.PrivateKey {
 	return u.privateKey
 }
 
-func readPrivateKey(path string) (crypto.PrivateKey, error) {
-	content, err := ioutil.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-	block, _ := pem.Decode(content)
-	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
-	if err != nil {
-		return nil, err
-	}
-	return privateKey.(crypto.PrivateKey), nil
-}
-
-func writeNewPrivateKey(path string) error {
-	privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
-	if err != nil {
-		return err
-	}
-	pkcsBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
-	if err != nil {
-		return err
-	}
-	return rw.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcsBytes}))
-}
-
 type Certificate struct {
 	Domain            string `json:"domain"`
 	CertURL           string `json:"certUrl"`
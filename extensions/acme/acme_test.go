@@ -0,0 +1,135 @@
+package acme
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when advanced. Every timer created is reported on timers, so
+// that tests can wait for the renewal loop to block.
+type fakeClock struct {
+	access  sync.Mutex
+	now     time.Time
+	pending []*fakeTimer
+	timers  chan time.Duration
+}
+
+type fakeTimer struct {
+	at      time.Time
+	c       chan time.Time
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:    time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		timers: make(chan time.Duration, 64),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.access.Lock()
+	defer c.access.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	c.access.Lock()
+	timer := &fakeTimer{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		timer.c <- c.now
+	} else {
+		c.pending = append(c.pending, timer)
+	}
+	c.access.Unlock()
+	c.timers <- d
+	return timer.c, func() bool {
+		c.access.Lock()
+		defer c.access.Unlock()
+		stopped := timer.stopped
+		timer.stopped = true
+		return !stopped
+	}
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.pending[:0]
+	for _, timer := range c.pending {
+		if timer.stopped {
+			continue
+		}
+		if !timer.at.After(c.now) {
+			timer.stopped = true
+			timer.c <- c.now
+			continue
+		}
+		pending = append(pending, timer)
+	}
+	c.pending = pending
+}
+
+// waitTimer returns the duration of the next timer created.
+func (c *fakeClock) waitTimer(t *testing.T) time.Duration {
+	t.Helper()
+	select {
+	case d := <-c.timers:
+		return d
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for timer")
+		return 0
+	}
+}
+
+// newTestManager returns a manager with memory storage and a fake clock, its ACME
+// server is unreachable unless settings point to one.
+func newTestManager(t *testing.T, settings *Settings) (*CertificateManager, *fakeClock) {
+	t.Helper()
+	if settings == nil {
+		settings = &Settings{}
+	}
+	if settings.DirectoryURL == "" {
+		settings.DirectoryURL = "http://127.0.0.1:1/directory"
+	}
+	if settings.KeyType == "" {
+		settings.KeyType = "ec256"
+	}
+	if settings.AccountKeyType == "" {
+		settings.AccountKeyType = "ec256"
+	}
+	manager, err := NewCertificateManagerWithStorage(settings, NewMemoryStorage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock()
+	manager.clock = clock
+	t.Cleanup(func() {
+		manager.Close()
+	})
+	return manager, clock
+}
+
+func TestNewCertificateManagerInvalidSettings(t *testing.T) {
+	settings := &Settings{DataDirectory: t.TempDir(), KeyType: "rsa1024"}
+	_, err := CreateCertificateManager(settings)
+	if err == nil {
+		t.Fatal("expected invalid key type to fail")
+	}
+	manager := NewCertificateManager(settings)
+	defer manager.Close()
+	_, err = manager.GetKeyPair("example.com")
+	if err == nil {
+		t.Fatal("expected requests to report invalid settings")
+	}
+	_, err = manager.TLSConfig([]string{"example.com"}, nil)
+	if err == nil {
+		t.Fatal("expected requests to report invalid settings")
+	}
+	_, err = manager.ListCertificates()
+	if err == nil {
+		t.Fatal("expected requests to report invalid settings")
+	}
+}
@@ -0,0 +1,101 @@
+package acme
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+// ACME Renewal Information (RFC 9773), not yet supported by lego.
+
+var errRenewalInfoUnsupported = errors.New("acme: renewal information not supported")
+
+type renewalInfo struct {
+	SuggestedWindow struct {
+		Start time.Time `json:"start"`
+		End   time.Time `json:"end"`
+	} `json:"suggestedWindow"`
+}
+
+func (c *CertificateManager) httpClient() (*http.Client, error) {
+	if c.rootCA != "" {
+		return newHTTPClient(c.rootCA)
+	}
+	return &http.Client{Timeout: 30 * time.Second}, nil
+}
+
+func (c *CertificateManager) renewalInfoURL(client *http.Client) (string, error) {
+	c.access.Lock()
+	renewalInfoURL, loaded := c.renewalInfoEndpoint, c.renewalInfoLoaded
+	c.access.Unlock()
+	if loaded {
+		return renewalInfoURL, nil
+	}
+	var directory struct {
+		RenewalInfo string `json:"renewalInfo"`
+	}
+	err := getJSON(client, c.directoryURL, &directory)
+	if err != nil {
+		return "", E.Cause(err, "acme: get directory")
+	}
+	c.access.Lock()
+	c.renewalInfoEndpoint, c.renewalInfoLoaded = directory.RenewalInfo, true
+	c.access.Unlock()
+	return directory.RenewalInfo, nil
+}
+
+func (c *CertificateManager) fetchRenewalInfo(leaf *x509.Certificate) (start time.Time, end time.Time, retryAfter time.Duration, err error) {
+	if len(leaf.AuthorityKeyId) == 0 {
+		err = errRenewalInfoUnsupported
+		return
+	}
+	client, err := c.httpClient()
+	if err != nil {
+		return
+	}
+	renewalInfoURL, err := c.renewalInfoURL(client)
+	if err != nil {
+		return
+	} else if renewalInfoURL == "" {
+		err = errRenewalInfoUnsupported
+		return
+	}
+	response, err := client.Get(renewalInfoURL + "/" + renewalInfoCertID(leaf))
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		err = E.New("HTTP ", response.StatusCode)
+		return
+	}
+	var info renewalInfo
+	err = json.NewDecoder(response.Body).Decode(&info)
+	if err != nil {
+		return
+	}
+	if info.SuggestedWindow.Start.IsZero() || info.SuggestedWindow.End.Before(info.SuggestedWindow.Start) {
+		err = E.New("bad suggested window")
+		return
+	}
+	if seconds, parseErr := strconv.ParseUint(response.Header.Get("Retry-After"), 10, 32); parseErr == nil {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	return info.SuggestedWindow.Start, info.SuggestedWindow.End, retryAfter, nil
+}
+
+// renewalInfoCertID is the base64url authority key identifier and serial number of leaf.
+func renewalInfoCertID(leaf *x509.Certificate) string {
+	serial := leaf.SerialNumber.Bytes()
+	if len(serial) == 0 || serial[0]&0x80 != 0 {
+		// DER integers are signed
+		serial = append([]byte{0}, serial...)
+	}
+	return base64.RawURLEncoding.EncodeToString(leaf.AuthorityKeyId) + "." + base64.RawURLEncoding.EncodeToString(serial)
+}
@@ -0,0 +1,159 @@
+package acme
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-acme/lego/v4/challenge/http01"
+	"github.com/go-acme/lego/v4/challenge/tlsalpn01"
+	"github.com/sagernet/sing/common"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+// httpChallengeProvider serves HTTP-01 key authorizations, either on its own
+// listener while a challenge is pending or through CertificateManager.HTTPChallengeHandler.
+type httpChallengeProvider struct {
+	listen string
+
+	access   sync.RWMutex
+	tokens   map[string]string
+	listener net.Listener
+}
+
+func newHTTPChallengeProvider(listen string) *httpChallengeProvider {
+	return &httpChallengeProvider{
+		listen: listen,
+		tokens: make(map[string]string),
+	}
+}
+
+func (p *httpChallengeProvider) Present(domain, token, keyAuth string) error {
+	p.access.Lock()
+	defer p.access.Unlock()
+	p.tokens[token] = keyAuth
+	if p.listen == "" || p.listener != nil {
+		return nil
+	}
+	listener, err := net.Listen("tcp", p.listen)
+	if err != nil {
+		delete(p.tokens, token)
+		return E.Cause(err, "acme: listen http challenge server")
+	}
+	p.listener = listener
+	go http.Serve(listener, p)
+	return nil
+}
+
+func (p *httpChallengeProvider) CleanUp(domain, token, keyAuth string) error {
+	p.access.Lock()
+	defer p.access.Unlock()
+	delete(p.tokens, token)
+	if len(p.tokens) == 0 && p.listener != nil {
+		p.listener.Close()
+		p.listener = nil
+	}
+	return nil
+}
+
+func (p *httpChallengeProvider) keyAuth(path string) (string, bool) {
+	prefix := http01.ChallengePath("")
+	if !strings.HasPrefix(path, prefix) {
+		return "", false
+	}
+	p.access.RLock()
+	defer p.access.RUnlock()
+	keyAuth, loaded := p.tokens[strings.TrimPrefix(path, prefix)]
+	return keyAuth, loaded
+}
+
+func (p *httpChallengeProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	keyAuth, loaded := p.keyAuth(r.URL.Path)
+	if !loaded || r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(keyAuth))
+}
+
+// tlsALPNChallengeProvider serves TLS-ALPN-01 challenge certificates, either on its own
+// listener while a challenge is pending or through CertificateManager.GetChallengeCertificate.
+type tlsALPNChallengeProvider struct {
+	listen string
+
+	access       sync.RWMutex
+	certificates map[string]*tls.Certificate
+	listener     net.Listener
+}
+
+func newTLSALPNChallengeProvider(listen string) *tlsALPNChallengeProvider {
+	return &tlsALPNChallengeProvider{
+		listen:       listen,
+		certificates: make(map[string]*tls.Certificate),
+	}
+}
+
+func (p *tlsALPNChallengeProvider) Present(domain, token, keyAuth string) error {
+	domain = strings.ToLower(domain)
+	certificate, err := tlsalpn01.ChallengeCert(domain, keyAuth)
+	if err != nil {
+		return err
+	}
+	p.access.Lock()
+	defer p.access.Unlock()
+	p.certificates[domain] = certificate
+	if p.listen == "" || p.listener != nil {
+		return nil
+	}
+	listener, err := tls.Listen("tcp", p.listen, &tls.Config{
+		GetCertificate: p.GetCertificate,
+		NextProtos:     []string{tlsalpn01.ACMETLS1Protocol},
+	})
+	if err != nil {
+		delete(p.certificates, domain)
+		return E.Cause(err, "acme: listen tls-alpn challenge server")
+	}
+	p.listener = listener
+	go p.loopAccept(listener)
+	return nil
+}
+
+func (p *tlsALPNChallengeProvider) loopAccept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}()
+	}
+}
+
+func (p *tlsALPNChallengeProvider) CleanUp(domain, token, keyAuth string) error {
+	p.access.Lock()
+	defer p.access.Unlock()
+	delete(p.certificates, strings.ToLower(domain))
+	if len(p.certificates) == 0 && p.listener != nil {
+		p.listener.Close()
+		p.listener = nil
+	}
+	return nil
+}
+
+func (p *tlsALPNChallengeProvider) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if !common.Contains(hello.SupportedProtos, tlsalpn01.ACMETLS1Protocol) {
+		return nil, nil
+	}
+	p.access.RLock()
+	defer p.access.RUnlock()
+	certificate, loaded := p.certificates[strings.ToLower(hello.ServerName)]
+	if !loaded {
+		return nil, E.New("acme: no tls-alpn challenge for ", hello.ServerName)
+	}
+	return certificate, nil
+}
@@ -0,0 +1,202 @@
+package acme
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/asn1"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-acme/lego/v4/challenge/http01"
+	"github.com/go-acme/lego/v4/challenge/tlsalpn01"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+var idPeAcmeIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// validateHTTP01 fetches the key authorization like an ACME server does.
+func validateHTTP01(baseURL string, token string) (string, error) {
+	response, err := http.Get(baseURL + http01.ChallengePath(token))
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", io.ErrUnexpectedEOF
+	}
+	content, err := io.ReadAll(response.Body)
+	return string(content), err
+}
+
+// validateTLSALPN01 checks the challenge certificate like an ACME server does.
+func validateTLSALPN01(t *testing.T, address string, domain string, keyAuth string) {
+	t.Helper()
+	err := checkTLSALPN01(address, domain, keyAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkTLSALPN01(address string, domain string, keyAuth string) error {
+	conn, err := tls.Dial("tcp", address, &tls.Config{
+		ServerName:         domain,
+		NextProtos:         []string{tlsalpn01.ACMETLS1Protocol},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != tlsalpn01.ACMETLS1Protocol {
+		return E.New("unexpected protocol ", state.NegotiatedProtocol)
+	}
+	leaf := state.PeerCertificates[0]
+	if len(leaf.DNSNames) != 1 || leaf.DNSNames[0] != domain {
+		return E.New("unexpected names ", leaf.DNSNames)
+	}
+	expected := sha256.Sum256([]byte(keyAuth))
+	for _, extension := range leaf.Extensions {
+		if !extension.Id.Equal(idPeAcmeIdentifier) {
+			continue
+		}
+		if !extension.Critical {
+			return E.New("acmeIdentifier extension not critical")
+		}
+		var digest []byte
+		_, err = asn1.Unmarshal(extension.Value, &digest)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(digest, expected[:]) {
+			return E.New("bad key authorization digest")
+		}
+		return nil
+	}
+	return E.New("missing acmeIdentifier extension")
+}
+
+func TestHTTPChallengeListener(t *testing.T) {
+	address := freeAddress(t)
+	provider := newHTTPChallengeProvider(address)
+	err := provider.Present("example.com", "token1", "token1.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = provider.Present("example.org", "token2", "token2.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for token, expected := range map[string]string{"token1": "token1.key", "token2": "token2.key"} {
+		keyAuth, err := validateHTTP01("http://"+address, token)
+		if err != nil || keyAuth != expected {
+			t.Fatal("unexpected key authorization ", keyAuth, err)
+		}
+	}
+	_, err = validateHTTP01("http://"+address, "unknown")
+	if err == nil {
+		t.Fatal("expected unknown token to fail")
+	}
+	provider.CleanUp("example.com", "token1", "token1.key")
+	_, err = validateHTTP01("http://"+address, "token1")
+	if err == nil {
+		t.Fatal("expected cleaned up token to fail")
+	}
+	provider.CleanUp("example.org", "token2", "token2.key")
+	_, err = net.Dial("tcp", address)
+	if err == nil {
+		t.Fatal("expected listener to be closed after clean up")
+	}
+}
+
+func TestHTTPChallengeHandler(t *testing.T) {
+	manager, _ := newTestManager(t, &Settings{HTTPChallenge: &ChallengeSettings{}})
+	server := httptest.NewServer(manager.HTTPChallengeHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("next"))
+	})))
+	defer server.Close()
+	err := manager.httpChallenge.Present("example.com", "token", "token.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyAuth, err := validateHTTP01(server.URL, "token")
+	if err != nil || keyAuth != "token.key" {
+		t.Fatal("unexpected key authorization ", keyAuth, err)
+	}
+	content, err := validateHTTP01(server.URL, "other")
+	if err != nil || content != "next" {
+		t.Fatal("expected other requests to reach next handler, got ", content, err)
+	}
+}
+
+func TestTLSALPNChallengeListener(t *testing.T) {
+	address := freeAddress(t)
+	provider := newTLSALPNChallengeProvider(address)
+	err := provider.Present("Example.com", "token", "token.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateTLSALPN01(t, address, "example.com", "token.key")
+	_, err = tls.Dial("tcp", address, &tls.Config{
+		ServerName:         "example.org",
+		NextProtos:         []string{tlsalpn01.ACMETLS1Protocol},
+		InsecureSkipVerify: true,
+	})
+	if err == nil {
+		t.Fatal("expected handshake for unknown name to fail")
+	}
+	provider.CleanUp("example.com", "token", "token.key")
+	_, err = net.Dial("tcp", address)
+	if err == nil {
+		t.Fatal("expected listener to be closed after clean up")
+	}
+}
+
+func TestTLSALPNChallengeConfig(t *testing.T) {
+	manager, _ := newTestManager(t, &Settings{TLSALPNChallenge: &ChallengeSettings{}})
+	config, err := manager.TLSConfig(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	err = manager.tlsALPNChallenge.Present("example.com", "token", "token.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateTLSALPN01(t, listener.Addr().String(), "example.com", "token.key")
+	_, err = tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+		ServerName:         "example.com",
+		InsecureSkipVerify: true,
+	})
+	if err == nil {
+		t.Fatal("expected handshake without acme-tls/1 not to get the challenge certificate")
+	}
+}
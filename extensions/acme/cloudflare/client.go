@@ -4,6 +4,7 @@ import (
 	"context"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/cloudflare/cloudflare-go"
 	E "github.com/sagernet/sing/common/exceptions"
@@ -13,50 +14,68 @@ type metaClient struct {
 	clientEdit *cloudflare.API // needs Zone/DNS/Edit permissions
 	clientRead *cloudflare.API // needs Zone/Zone/Read permissions
 
-	zones   map[string]string // caches calls to ZoneIDByName
+	zones   map[string]cachedZone // caches calls to ZoneIDByName
 	zonesMu *sync.RWMutex
+
+	nameServers   map[string][]string // caches calls to ZoneNameServers
+	nameServersMu sync.Mutex
+}
+
+const zoneCacheTTL = time.Hour
+
+type cachedZone struct {
+	id      string
+	expires time.Time
 }
 
 func newClient(config *Config) (*metaClient, error) {
+	options := []cloudflare.Option{cloudflare.HTTPClient(config.HTTPClient)}
+	if config.BaseURL != "" {
+		options = append(options, cloudflare.BaseURL(config.BaseURL))
+	}
+
 	// with AuthKey/AuthEmail we can access all available APIs
 	if config.AuthToken == "" {
-		client, err := cloudflare.New(config.AuthKey, config.AuthEmail, cloudflare.HTTPClient(config.HTTPClient))
+		client, err := cloudflare.New(config.AuthKey, config.AuthEmail, options...)
 		if err != nil {
 			return nil, err
 		}
 
 		return &metaClient{
-			clientEdit: client,
-			clientRead: client,
-			zones:      make(map[string]string),
-			zonesMu:    &sync.RWMutex{},
+			clientEdit:  client,
+			clientRead:  client,
+			zones:       make(map[string]cachedZone),
+			zonesMu:     &sync.RWMutex{},
+			nameServers: make(map[string][]string),
 		}, nil
 	}
 
-	dns, err := cloudflare.NewWithAPIToken(config.AuthToken, cloudflare.HTTPClient(config.HTTPClient))
+	dns, err := cloudflare.NewWithAPIToken(config.AuthToken, options...)
 	if err != nil {
 		return nil, err
 	}
 
 	if config.ZoneToken == "" || config.ZoneToken == config.AuthToken {
 		return &metaClient{
-			clientEdit: dns,
-			clientRead: dns,
-			zones:      make(map[string]string),
-			zonesMu:    &sync.RWMutex{},
+			clientEdit:  dns,
+			clientRead:  dns,
+			zones:       make(map[string]cachedZone),
+			zonesMu:     &sync.RWMutex{},
+			nameServers: make(map[string][]string),
 		}, nil
 	}
 
-	zone, err := cloudflare.NewWithAPIToken(config.ZoneToken, cloudflare.HTTPClient(config.HTTPClient))
+	zone, err := cloudflare.NewWithAPIToken(config.ZoneToken, options...)
 	if err != nil {
 		return nil, err
 	}
 
 	return &metaClient{
-		clientEdit: dns,
-		clientRead: zone,
-		zones:      make(map[string]string),
-		zonesMu:    &sync.RWMutex{},
+		clientEdit:  dns,
+		clientRead:  zone,
+		zones:       make(map[string]cachedZone),
+		zonesMu:     &sync.RWMutex{},
+		nameServers: make(map[string][]string),
 	}, nil
 }
 
@@ -72,37 +91,69 @@ func (m *metaClient) DeleteDNSRecord(ctx context.Context, zoneID, recordID strin
 	return m.clientEdit.DeleteDNSRecord(ctx, zoneID, recordID)
 }
 
-func (m *metaClient) ZoneIDByName(fqdn string) (string, error) {
-	if fqdn[len(fqdn)-1] == '.' {
-		fqdn = fqdn[:len(fqdn)-1]
+// ZoneNameServers returns the nameservers Cloudflare assigned to the zone.
+func (m *metaClient) ZoneNameServers(ctx context.Context, zoneID string) ([]string, error) {
+	m.nameServersMu.Lock()
+	nameServers, loaded := m.nameServers[zoneID]
+	m.nameServersMu.Unlock()
+	if loaded {
+		return nameServers, nil
 	}
+	zone, err := m.clientRead.ZoneDetails(ctx, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	m.nameServersMu.Lock()
+	m.nameServers[zoneID] = zone.NameServers
+	m.nameServersMu.Unlock()
+	return zone.NameServers, nil
+}
 
-	m.zonesMu.RLock()
-
-	for name, zoneId := range m.zones {
-		if strings.HasSuffix(fqdn, name) {
-			return zoneId, nil
+// ZoneIDByName returns the zone of fqdn, which is the longest zone name matching whole
+// labels of fqdn. Zones are looked up by name and cached for zoneCacheTTL.
+func (m *metaClient) ZoneIDByName(ctx context.Context, fqdn string) (string, error) {
+	name := strings.ToLower(strings.TrimSuffix(fqdn, "."))
+	labels := strings.Split(name, ".")
+	// zones are at least second level domains
+	for index := 0; index < len(labels)-1; index++ {
+		zoneName := strings.Join(labels[index:], ".")
+		zoneID, loaded := m.cachedZone(zoneName)
+		if !loaded {
+			response, err := m.clientRead.ListZonesContext(ctx, cloudflare.WithZoneFilters(zoneName, "", ""))
+			if err != nil {
+				return "", err
+			}
+			for _, zone := range response.Result {
+				if strings.EqualFold(zone.Name, zoneName) {
+					zoneID = zone.ID
+					break
+				}
+			}
+			m.storeZone(zoneName, zoneID)
+		}
+		if zoneID != "" {
+			return zoneID, nil
 		}
 	}
+	return "", E.New("zone not found for domain ", name)
+}
 
-	m.zonesMu.RUnlock()
-
-	zones, err := m.clientRead.ListZones(context.Background())
-	if err != nil {
-		return "", err
+func (m *metaClient) cachedZone(name string) (string, bool) {
+	m.zonesMu.RLock()
+	defer m.zonesMu.RUnlock()
+	zone, loaded := m.zones[name]
+	if !loaded || time.Now().After(zone.expires) {
+		return "", false
 	}
+	return zone.id, true
+}
 
+// storeZone caches a zone, or that no zone of the name exists if id is empty.
+func (m *metaClient) storeZone(name string, id string) {
 	m.zonesMu.Lock()
-	for _, zone := range zones {
-		m.zones[zone.Name] = zone.ID
+	defer m.zonesMu.Unlock()
+	m.zones[name] = cachedZone{
+		id:      id,
+		expires: time.Now().Add(zoneCacheTTL),
 	}
-	m.zonesMu.Unlock()
-
-	for name, zoneId := range m.zones {
-		if strings.HasSuffix(fqdn, name) {
-			return zoneId, nil
-		}
-	}
-
-	return "", E.New("zone not found for domain ", fqdn)
 }
@@ -0,0 +1,177 @@
+package cloudflare
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testZone struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	NameServers []string `json:"name_servers"`
+}
+
+// testAPI answers zone lookups like the Cloudflare API, matching zone names loosely
+// so that the client has to pick whole labels itself.
+type testAPI struct {
+	zones []testZone
+
+	access   sync.Mutex
+	requests []string
+}
+
+func (a *testAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.access.Lock()
+	a.requests = append(a.requests, r.URL.Path+"?"+r.URL.Query().Get("name"))
+	allZones := a.zones
+	a.access.Unlock()
+	var result any
+	switch {
+	case r.URL.Path == "/zones":
+		name := r.URL.Query().Get("name")
+		zones := []testZone{}
+		for _, zone := range allZones {
+			if strings.HasSuffix(zone.Name, name) {
+				zones = append(zones, zone)
+			}
+		}
+		result = zones
+	case strings.HasPrefix(r.URL.Path, "/zones/"):
+		for _, zone := range allZones {
+			if zone.ID == strings.TrimPrefix(r.URL.Path, "/zones/") {
+				result = zone
+			}
+		}
+		if result == nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]any{"success": false, "errors": []any{map[string]any{"code": 1001, "message": "not found"}}})
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"success":     true,
+		"errors":      []any{},
+		"messages":    []any{},
+		"result":      result,
+		"result_info": map[string]any{"page": 1, "per_page": 50, "total_pages": 1},
+	})
+}
+
+func (a *testAPI) takeRequests() []string {
+	a.access.Lock()
+	defer a.access.Unlock()
+	requests := a.requests
+	a.requests = nil
+	return requests
+}
+
+func newTestClient(t *testing.T) (*metaClient, *testAPI) {
+	api := &testAPI{zones: []testZone{
+		{ID: "example", Name: "example.com", NameServers: []string{"a.ns.cloudflare.com", "b.ns.cloudflare.com"}},
+		{ID: "myexample", Name: "myexample.com"},
+		{ID: "sub", Name: "sub.example.com"},
+	}}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	client, err := newClient(&Config{AuthToken: "token", BaseURL: server.URL, HTTPClient: server.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, api
+}
+
+func TestZoneIDByName(t *testing.T) {
+	client, _ := newTestClient(t)
+	for fqdn, expected := range map[string]string{
+		"_acme-challenge.example.com.":       "example",
+		"_acme-challenge.WWW.Example.COM.":   "example",
+		"_acme-challenge.myexample.com.":     "myexample",
+		"_acme-challenge.a.sub.example.com.": "sub",
+		"_acme-challenge.notsub.example.com": "example",
+	} {
+		zoneID, err := client.ZoneIDByName(context.Background(), fqdn)
+		if err != nil {
+			t.Fatal(fqdn, ": ", err)
+		}
+		if zoneID != expected {
+			t.Error(fqdn, ": expected zone ", expected, ", got ", zoneID)
+		}
+	}
+	_, err := client.ZoneIDByName(context.Background(), "_acme-challenge.example.org.")
+	if err == nil {
+		t.Fatal("expected missing zone to fail")
+	}
+}
+
+func TestZoneIDByNameCache(t *testing.T) {
+	client, api := newTestClient(t)
+	ctx := context.Background()
+	_, err := client.ZoneIDByName(ctx, "_acme-challenge.www.example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := api.takeRequests()
+	if len(requests) != 3 {
+		t.Fatal("expected a lookup per label, got ", requests)
+	}
+
+	// positive and negative entries are cached
+	_, err = client.ZoneIDByName(ctx, "_acme-challenge.www.example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests = api.takeRequests(); len(requests) != 0 {
+		t.Fatal("expected cached zone, got ", requests)
+	}
+	_, err = client.ZoneIDByName(ctx, "_acme-challenge.api.example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests = api.takeRequests(); len(requests) != 2 {
+		t.Fatal("expected only uncached names to be looked up, got ", requests)
+	}
+
+	// a zone added after a negative entry is found once it expires
+	api.access.Lock()
+	api.zones = append(api.zones, testZone{ID: "www", Name: "www.example.com"})
+	api.access.Unlock()
+	zoneID, _ := client.ZoneIDByName(ctx, "_acme-challenge.www.example.com.")
+	if zoneID != "example" {
+		t.Fatal("expected negative entry to be used, got ", zoneID)
+	}
+	client.zonesMu.Lock()
+	for name, zone := range client.zones {
+		zone.expires = time.Now().Add(-time.Second)
+		client.zones[name] = zone
+	}
+	client.zonesMu.Unlock()
+	zoneID, _ = client.ZoneIDByName(ctx, "_acme-challenge.www.example.com.")
+	if zoneID != "www" {
+		t.Fatal("expected expired entries to be looked up again, got ", zoneID)
+	}
+}
+
+func TestZoneNameServers(t *testing.T) {
+	client, api := newTestClient(t)
+	for i := 0; i < 2; i++ {
+		nameServers, err := client.ZoneNameServers(context.Background(), "example")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(nameServers, ",") != "a.ns.cloudflare.com,b.ns.cloudflare.com" {
+			t.Fatal("unexpected name servers ", nameServers)
+		}
+	}
+	if requests := api.takeRequests(); len(requests) != 1 {
+		t.Fatal("expected cached name servers, got ", requests)
+	}
+}
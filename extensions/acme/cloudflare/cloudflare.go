@@ -5,7 +5,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,10 +15,13 @@ import (
 	"github.com/go-acme/lego/v4/challenge/dns01"
 	"github.com/go-acme/lego/v4/log"
 	"github.com/go-acme/lego/v4/platform/config/env"
+	"github.com/miekg/dns"
 )
 
 const (
 	minTTL = 120
+	// records of the challenge name older than this are left by crashed runs
+	staleRecordAge = 10 * time.Minute
 )
 
 // Config is used to configure the creation of the DNSProvider.
@@ -31,6 +36,11 @@ type Config struct {
 	PropagationTimeout time.Duration
 	PollingInterval    time.Duration
 	HTTPClient         *http.Client
+	// BaseURL overrides the Cloudflare API endpoint.
+	BaseURL string
+	// AuthoritativeCheck verifies propagation by querying the zone's Cloudflare
+	// nameservers directly.
+	AuthoritativeCheck bool
 }
 
 // NewDefaultConfig returns a default configuration for the DNSProvider.
@@ -120,15 +130,55 @@ func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
 	return d.config.PropagationTimeout, d.config.PollingInterval
 }
 
-// Present creates a TXT record to fulfill the dns-01 challenge.
+// Present creates a TXT record to fulfill the dns-01 challenge. A record with the same
+// content is reused, and stale records of the same name left by crashed runs are deleted.
 func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	ctx := context.Background()
 	fqdn, value := dns01.GetRecord(domain, keyAuth)
 
-	zoneID, err := d.client.ZoneIDByName(fqdn)
+	zoneID, err := d.client.ZoneIDByName(ctx, fqdn)
 	if err != nil {
 		return fmt.Errorf("cloudflare: failed to find zone %s: %w", fqdn, err)
 	}
 
+	records, err := d.client.DNSRecords(ctx, zoneID, cloudflare.DNSRecord{Type: "TXT", Name: dns01.UnFqdn(fqdn)})
+	if err != nil {
+		return fmt.Errorf("cloudflare: failed to list TXT records: %w", err)
+	}
+
+	d.recordIDsMu.Lock()
+	owned := make(map[string]bool, len(d.recordIDs))
+	for _, recordID := range d.recordIDs {
+		owned[recordID] = true
+	}
+	d.recordIDsMu.Unlock()
+
+	var reusedID string
+	for _, record := range records {
+		if record.Content == value {
+			reusedID = record.ID
+			continue
+		}
+		// records of concurrent challenges for the same name are young or our own
+		if owned[record.ID] || time.Since(record.CreatedOn) < staleRecordAge {
+			continue
+		}
+		err = d.client.DeleteDNSRecord(ctx, zoneID, record.ID)
+		if err != nil {
+			log.Warnf("cloudflare: failed to delete stale TXT record %s: %v", record.ID, err)
+		} else {
+			log.Infof("cloudflare: deleted stale TXT record for %s, ID %s", domain, record.ID)
+		}
+	}
+
+	if reusedID != "" {
+		d.recordIDsMu.Lock()
+		d.recordIDs[token] = reusedID
+		d.recordIDsMu.Unlock()
+		log.Infof("cloudflare: reuse record for %s, ID %s", domain, reusedID)
+		return nil
+	}
+
 	dnsRecord := cloudflare.DNSRecord{
 		Type:    "TXT",
 		Name:    dns01.UnFqdn(fqdn),
@@ -136,7 +186,7 @@ func (d *DNSProvider) Present(domain, token, keyAuth string) error {
 		TTL:     d.config.TTL,
 	}
 
-	response, err := d.client.CreateDNSRecord(context.Background(), zoneID, dnsRecord)
+	response, err := d.client.CreateDNSRecord(ctx, zoneID, dnsRecord)
 	if err != nil {
 		return fmt.Errorf("cloudflare: failed to create TXT record: %w", err)
 	}
@@ -154,11 +204,13 @@ func (d *DNSProvider) Present(domain, token, keyAuth string) error {
 	return nil
 }
 
-// CleanUp removes the TXT record matching the specified parameters.
+// CleanUp removes the TXT record matching the specified parameters, searching it by name
+// and content if it was not created by this provider.
 func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
-	fqdn, _ := dns01.GetRecord(domain, keyAuth)
+	ctx := context.Background()
+	fqdn, value := dns01.GetRecord(domain, keyAuth)
 
-	zoneID, err := d.client.ZoneIDByName(fqdn)
+	zoneID, err := d.client.ZoneIDByName(ctx, fqdn)
 	if err != nil {
 		return fmt.Errorf("cloudflare: failed to find zone %s: %w", fqdn, err)
 	}
@@ -166,20 +218,70 @@ func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
 	// get the record's unique ID from when we created it
 	d.recordIDsMu.Lock()
 	recordID, ok := d.recordIDs[token]
+	delete(d.recordIDs, token)
 	d.recordIDsMu.Unlock()
-	if !ok {
-		return fmt.Errorf("cloudflare: unknown record ID for '%s'", fqdn)
-	}
 
-	err = d.client.DeleteDNSRecord(context.Background(), zoneID, recordID)
-	if err != nil {
-		log.Printf("cloudflare: failed to delete TXT record: %w", err)
+	var recordIDs []string
+	if ok {
+		recordIDs = []string{recordID}
+	} else {
+		records, err := d.client.DNSRecords(ctx, zoneID, cloudflare.DNSRecord{Type: "TXT", Name: dns01.UnFqdn(fqdn), Content: value})
+		if err != nil {
+			return fmt.Errorf("cloudflare: failed to find TXT record for '%s': %w", fqdn, err)
+		}
+		for _, record := range records {
+			recordIDs = append(recordIDs, record.ID)
+		}
 	}
 
-	// Delete record ID from map
-	d.recordIDsMu.Lock()
-	delete(d.recordIDs, token)
-	d.recordIDsMu.Unlock()
+	for _, recordID := range recordIDs {
+		err = d.client.DeleteDNSRecord(ctx, zoneID, recordID)
+		if err != nil {
+			log.Warnf("cloudflare: failed to delete TXT record: %v", err)
+		}
+	}
 
 	return nil
 }
+
+// PreCheck checks the record at the authoritative nameservers of the zone as assigned by
+// Cloudflare if AuthoritativeCheck is set, instead of discovering them through the
+// recursive resolvers.
+func (d *DNSProvider) PreCheck(domain, fqdn, value string, check dns01.PreCheckFunc) (bool, error) {
+	if !d.config.AuthoritativeCheck {
+		return check(fqdn, value)
+	}
+	ctx := context.Background()
+	zoneID, err := d.client.ZoneIDByName(ctx, fqdn)
+	if err != nil {
+		return false, fmt.Errorf("cloudflare: failed to find zone %s: %w", fqdn, err)
+	}
+	nameservers, err := d.client.ZoneNameServers(ctx, zoneID)
+	if err != nil {
+		return false, fmt.Errorf("cloudflare: failed to get nameservers of zone %s: %w", zoneID, err)
+	}
+	if len(nameservers) == 0 {
+		return check(fqdn, value)
+	}
+	client := &dns.Client{Timeout: 10 * time.Second}
+	for _, nameserver := range nameservers {
+		message := new(dns.Msg)
+		message.SetQuestion(dns.Fqdn(fqdn), dns.TypeTXT)
+		message.RecursionDesired = false
+		response, _, err := client.Exchange(message, net.JoinHostPort(nameserver, "53"))
+		if err != nil {
+			return false, fmt.Errorf("cloudflare: query %s: %w", nameserver, err)
+		}
+		var found bool
+		for _, answer := range response.Answer {
+			if txt, isTXT := answer.(*dns.TXT); isTXT && strings.Join(txt.Txt, "") == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, fmt.Errorf("cloudflare: TXT record for %s not yet at %s", fqdn, nameserver)
+		}
+	}
+	return true, nil
+}
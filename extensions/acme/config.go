@@ -3,18 +3,64 @@ package acme
 import (
 	"encoding/json"
 	"os"
+	"strconv"
 
-	"github.com/go-acme/lego/v4/challenge"
-	"github.com/sagernet/sing-tools/extensions/acme/cloudflare"
 	E "github.com/sagernet/sing/common/exceptions"
 )
 
 type Settings struct {
-	Enabled       bool     `json:"enabled"`
-	DataDirectory string   `json:"data_directory"`
-	Email         string   `json:"email"`
-	DNSProvider   string   `json:"dns_provider"`
-	DNSEnv        *JSONMap `json:"dns_env"`
+	Enabled       bool             `json:"enabled"`
+	DataDirectory string           `json:"data_directory"`
+	Storage       *StorageSettings `json:"storage"`
+	Email         string           `json:"email"`
+	DNSProvider   string           `json:"dns_provider"`
+	// DNSCredentials configure the DNS provider without changing the environment. If
+	// neither DNSCredentials nor DNSEnv is set, the provider reads its credentials from
+	// the environment as before.
+	DNSCredentials Credentials `json:"dns_credentials"`
+	// Deprecated: DNSEnv is no longer copied into the environment, its entries are used as
+	// DNSCredentials, which take precedence. Move them to dns_credentials.
+	DNSEnv *JSONMap `json:"dns_env"`
+	// DNSCredentialsFromEnv additionally reads credentials missing in the configuration
+	// from the environment. Only the names used by the DNS provider (and NAME_FILE for
+	// each of them) are read.
+	DNSCredentialsFromEnv bool `json:"dns_credentials_from_env"`
+
+	HTTPChallenge    *ChallengeSettings `json:"http_challenge"`
+	TLSALPNChallenge *ChallengeSettings `json:"tls_alpn_challenge"`
+
+	// DirectoryURL is the ACME directory, or one of letsencrypt (default),
+	// letsencrypt_staging, zerossl, buypass and buypass_staging.
+	DirectoryURL    string                   `json:"directory_url"`
+	ExternalAccount *ExternalAccountSettings `json:"external_account"`
+	// RootCA is the path to a PEM bundle used to verify the ACME server, e.g. an internal
+	// step-ca.
+	RootCA string `json:"root_ca"`
+
+	// KeyType and AccountKeyType are one of ec256, ec384, rsa2048 and rsa4096 (default).
+	// The account key type only applies when a new account is created.
+	KeyType        string `json:"key_type"`
+	AccountKeyType string `json:"account_key_type"`
+	// DualCertificate additionally obtains an ECDSA certificate for RSA key types or an
+	// RSA one for ECDSA key types, so that clients can be served by their capability.
+	DualCertificate bool `json:"dual_certificate"`
+	// RenewFraction is the fraction of the certificate lifetime after which it is renewed,
+	// 2/3 by default. Suggestions of ACME Renewal Information take precedence.
+	RenewFraction float64 `json:"renew_fraction"`
+	// DisableOCSPStapling stops fetching OCSP responses for certificates with an OCSP server.
+	DisableOCSPStapling bool `json:"disable_ocsp_stapling"`
+}
+
+type ExternalAccountSettings struct {
+	KeyID  string `json:"key_id"`
+	MACKey string `json:"mac_key"`
+}
+
+// ChallengeSettings enables the HTTP-01 or TLS-ALPN-01 challenge. Without a listen address
+// the challenge is only served through CertificateManager.HTTPChallengeHandler or
+// CertificateManager.GetChallengeCertificate.
+type ChallengeSettings struct {
+	Listen string `json:"listen"`
 }
 
 type JSONMap struct {
@@ -40,9 +86,17 @@ func (m *JSONMap) UnmarshalJSON(data []byte) error {
 	return json.Unmarshal(data, &m.Data)
 }
 
+// SetupEnvironment copies DNSEnv into the environment of the process.
+//
+// Deprecated: DNSEnv is used by the CertificateManager without changing the
+// environment, so that managers with different credentials can coexist.
 func (s *Settings) SetupEnvironment() error {
-	for envName, envValue := range s.DNSEnv.Data {
-		err := os.Setenv(envName, envValue.(string))
+	credentials, err := s.DNSEnv.credentials()
+	if err != nil {
+		return err
+	}
+	for name, value := range credentials {
+		err = os.Setenv(name, value)
 		if err != nil {
 			return err
 		}
@@ -50,11 +104,40 @@ func (s *Settings) SetupEnvironment() error {
 	return nil
 }
 
-func NewDNSChallengeProviderByName(name string) (challenge.Provider, error) {
-	switch name {
-	case "cloudflare":
-		return cloudflare.NewDNSProvider()
+func (m *JSONMap) credentials() (Credentials, error) {
+	if m == nil {
+		return nil, nil
+	}
+	credentials := make(Credentials, len(m.Data))
+	for name, value := range m.Data {
+		switch value := value.(type) {
+		case nil:
+		case string:
+			credentials[name] = value
+		case float64:
+			credentials[name] = strconv.FormatFloat(value, 'f', -1, 64)
+		case bool:
+			credentials[name] = strconv.FormatBool(value)
+		default:
+			return nil, E.New("dns_env ", name, ": expected string, number or boolean")
+		}
+	}
+	return credentials, nil
+}
+
+func (s *Settings) dnsCredentials() (Credentials, error) {
+	credentials, err := s.DNSEnv.credentials()
+	if err != nil {
+		return nil, err
+	}
+	if credentials == nil {
+		credentials = make(Credentials, len(s.DNSCredentials))
+	}
+	for name, value := range s.DNSCredentials {
+		credentials[name] = value
+	}
+	if s.DNSCredentialsFromEnv || len(credentials) == 0 {
+		credentials = credentials.withEnvironment(dnsProviderNames(s.DNSProvider))
 	}
-	// return dns.NewDNSChallengeProviderByName(name)
-	return nil, E.New("unsupported dns provider ", name)
+	return credentials.Resolve()
 }
@@ -0,0 +1,57 @@
+package acme
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/go-acme/lego/v4/lego"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+const (
+	ZeroSSLDirectory        = "https://acme.zerossl.com/v2/DV90"
+	BuypassDirectory        = "https://api.buypass.com/acme/directory"
+	BuypassStagingDirectory = "https://api.test4.buypass.no/acme/directory"
+)
+
+func directoryURL(name string) string {
+	switch name {
+	case "", "letsencrypt":
+		return lego.LEDirectoryProduction
+	case "letsencrypt_staging":
+		return lego.LEDirectoryStaging
+	case "zerossl":
+		return ZeroSSLDirectory
+	case "buypass":
+		return BuypassDirectory
+	case "buypass_staging":
+		return BuypassStagingDirectory
+	default:
+		return name
+	}
+}
+
+func newHTTPClient(rootCA string) (*http.Client, error) {
+	content, err := ioutil.ReadFile(rootCA)
+	if err != nil {
+		return nil, E.Cause(err, "acme: read root ca")
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(content) {
+		return nil, E.New("acme: no certificate found in root ca ", rootCA)
+	}
+	return &http.Client{
+		Timeout: 2 * time.Minute,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			TLSHandshakeTimeout:   15 * time.Second,
+			ResponseHeaderTimeout: 15 * time.Second,
+			TLSClientConfig: &tls.Config{
+				RootCAs: certPool,
+			},
+		},
+	}, nil
+}
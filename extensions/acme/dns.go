@@ -0,0 +1,296 @@
+package acme
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/providers/dns/exec"
+	"github.com/go-acme/lego/v4/providers/dns/rfc2136"
+	"github.com/miekg/dns"
+	"github.com/sagernet/sing-tools/extensions/acme/cloudflare"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+// Credentials configure a DNS provider, using the names of the corresponding lego
+// environment variables like RFC2136_NAMESERVER. A NAME_FILE entry reads NAME from a
+// file once resolved.
+type Credentials map[string]string
+
+// UnmarshalJSON accepts strings, numbers and booleans.
+func (c *Credentials) UnmarshalJSON(content []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var values map[string]any
+	err := decoder.Decode(&values)
+	if err != nil {
+		return err
+	}
+	*c = make(Credentials, len(values))
+	for name, value := range values {
+		switch value := value.(type) {
+		case nil:
+		case string:
+			(*c)[name] = value
+		case json.Number:
+			(*c)[name] = value.String()
+		case bool:
+			(*c)[name] = strconv.FormatBool(value)
+		default:
+			return E.New("dns credential ", name, ": expected string, number or boolean")
+		}
+	}
+	return nil
+}
+
+// Resolve returns a copy with NAME_FILE entries replaced by NAME read from the file.
+func (c Credentials) Resolve() (Credentials, error) {
+	resolved := make(Credentials, len(c))
+	for name, value := range c {
+		if !strings.HasSuffix(name, "_FILE") {
+			resolved[name] = value
+		}
+	}
+	for fileName, path := range c {
+		if !strings.HasSuffix(fileName, "_FILE") {
+			continue
+		}
+		name := strings.TrimSuffix(fileName, "_FILE")
+		if _, loaded := c[name]; loaded {
+			continue
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, E.Cause(err, "read dns credential ", name)
+		}
+		resolved[name] = strings.TrimSpace(string(content))
+	}
+	return resolved, nil
+}
+
+// withEnvironment returns a copy with names missing in c read from the environment,
+// either as NAME or as NAME_FILE.
+func (c Credentials) withEnvironment(names []string) Credentials {
+	merged := make(Credentials, len(c)+len(names))
+	for name, value := range c {
+		merged[name] = value
+	}
+	for _, name := range names {
+		if _, loaded := c[name]; loaded {
+			continue
+		}
+		if _, loaded := c[name+"_FILE"]; loaded {
+			continue
+		}
+		if value, loaded := os.LookupEnv(name); loaded {
+			merged[name] = value
+		} else if path, loaded := os.LookupEnv(name + "_FILE"); loaded {
+			merged[name+"_FILE"] = path
+		}
+	}
+	return merged
+}
+
+func (c Credentials) Get(name string) string {
+	return c[name]
+}
+
+// First returns the first non-empty value of names.
+func (c Credentials) First(names ...string) string {
+	for _, name := range names {
+		if value := c.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (c Credentials) Int(name string, defaultValue int) int {
+	value, err := strconv.Atoi(c.Get(name))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// Seconds reads a duration given in seconds.
+func (c Credentials) Seconds(name string, defaultValue time.Duration) time.Duration {
+	value := c.Int(name, -1)
+	if value < 0 {
+		return defaultValue
+	}
+	return time.Duration(value) * time.Second
+}
+
+type DNSProviderConstructor func(credentials Credentials) (challenge.Provider, error)
+
+// dnsPreChecker is implemented by providers replacing the propagation check of lego.
+type dnsPreChecker interface {
+	PreCheck(domain, fqdn, value string, check dns01.PreCheckFunc) (bool, error)
+}
+
+type dnsProvider struct {
+	constructor DNSProviderConstructor
+	names       []string
+}
+
+var (
+	dnsProviderAccess sync.RWMutex
+	dnsProviders      = make(map[string]dnsProvider)
+)
+
+func init() {
+	RegisterDNSProvider("cloudflare", newCloudflareProvider, cloudflareCredentialNames...)
+	RegisterDNSProvider("rfc2136", newRFC2136Provider, rfc2136CredentialNames...)
+	RegisterDNSProvider("exec", newExecProvider, execCredentialNames...)
+}
+
+// RegisterDNSProvider makes a DNS provider available by name, replacing the one
+// registered before. names are the credentials read from the environment if it is
+// used as fallback.
+func RegisterDNSProvider(name string, constructor DNSProviderConstructor, names ...string) {
+	dnsProviderAccess.Lock()
+	defer dnsProviderAccess.Unlock()
+	dnsProviders[name] = dnsProvider{constructor, names}
+}
+
+func loadDNSProvider(name string) (dnsProvider, bool) {
+	dnsProviderAccess.RLock()
+	defer dnsProviderAccess.RUnlock()
+	provider, loaded := dnsProviders[name]
+	return provider, loaded
+}
+
+func dnsProviderNames(name string) []string {
+	provider, _ := loadDNSProvider(name)
+	return provider.names
+}
+
+// NewDNSChallengeProviderByName creates a DNS provider with credentials read from the
+// environment.
+//
+// Deprecated: use NewDNSChallengeProviderWithCredentials.
+func NewDNSChallengeProviderByName(name string) (challenge.Provider, error) {
+	credentials, err := Credentials(nil).withEnvironment(dnsProviderNames(name)).Resolve()
+	if err != nil {
+		return nil, err
+	}
+	return NewDNSChallengeProviderWithCredentials(name, credentials)
+}
+
+// NewDNSChallengeProviderWithCredentials creates a DNS provider with resolved credentials.
+func NewDNSChallengeProviderWithCredentials(name string, credentials Credentials) (challenge.Provider, error) {
+	provider, loaded := loadDNSProvider(name)
+	if !loaded {
+		return nil, E.New("unsupported dns provider ", name)
+	}
+	return provider.constructor(credentials)
+}
+
+var cloudflareCredentialNames = []string{
+	"CLOUDFLARE_EMAIL", "CF_API_EMAIL",
+	"CLOUDFLARE_API_KEY", "CF_API_KEY",
+	"CLOUDFLARE_DNS_API_TOKEN", "CF_DNS_API_TOKEN",
+	"CLOUDFLARE_ZONE_API_TOKEN", "CF_ZONE_API_TOKEN",
+	"CLOUDFLARE_TTL",
+	"CLOUDFLARE_PROPAGATION_TIMEOUT",
+	"CLOUDFLARE_POLLING_INTERVAL",
+	"CLOUDFLARE_HTTP_TIMEOUT",
+	"CLOUDFLARE_AUTHORITATIVE_CHECK",
+}
+
+// newCloudflareProvider authenticates with CLOUDFLARE_DNS_API_TOKEN (and optionally a
+// separate CLOUDFLARE_ZONE_API_TOKEN), or CLOUDFLARE_EMAIL and CLOUDFLARE_API_KEY.
+func newCloudflareProvider(credentials Credentials) (challenge.Provider, error) {
+	config := &cloudflare.Config{
+		AuthEmail:          credentials.First("CLOUDFLARE_EMAIL", "CF_API_EMAIL"),
+		AuthKey:            credentials.First("CLOUDFLARE_API_KEY", "CF_API_KEY"),
+		AuthToken:          credentials.First("CLOUDFLARE_DNS_API_TOKEN", "CF_DNS_API_TOKEN"),
+		ZoneToken:          credentials.First("CLOUDFLARE_ZONE_API_TOKEN", "CF_ZONE_API_TOKEN"),
+		TTL:                credentials.Int("CLOUDFLARE_TTL", 120),
+		PropagationTimeout: credentials.Seconds("CLOUDFLARE_PROPAGATION_TIMEOUT", 2*time.Minute),
+		PollingInterval:    credentials.Seconds("CLOUDFLARE_POLLING_INTERVAL", 2*time.Second),
+		HTTPClient: &http.Client{
+			Timeout: credentials.Seconds("CLOUDFLARE_HTTP_TIMEOUT", 30*time.Second),
+		},
+		AuthoritativeCheck: credentials.Get("CLOUDFLARE_AUTHORITATIVE_CHECK") == "true",
+	}
+	if config.AuthToken != "" {
+		config.AuthEmail, config.AuthKey = "", ""
+		if config.ZoneToken == "" {
+			config.ZoneToken = config.AuthToken
+		}
+	} else if config.AuthEmail == "" || config.AuthKey == "" {
+		return nil, E.New("cloudflare: missing CLOUDFLARE_DNS_API_TOKEN, or CLOUDFLARE_EMAIL and CLOUDFLARE_API_KEY")
+	}
+	return cloudflare.NewDNSProviderConfig(config)
+}
+
+var rfc2136CredentialNames = []string{
+	rfc2136.EnvNameserver,
+	rfc2136.EnvTSIGAlgorithm,
+	rfc2136.EnvTSIGKey,
+	rfc2136.EnvTSIGSecret,
+	rfc2136.EnvTTL,
+	rfc2136.EnvPropagationTimeout,
+	rfc2136.EnvPollingInterval,
+	rfc2136.EnvSequenceInterval,
+	rfc2136.EnvDNSTimeout,
+}
+
+// newRFC2136Provider updates zones by RFC 2136 dynamic updates, signed with TSIG if
+// RFC2136_TSIG_KEY and RFC2136_TSIG_SECRET are set. Unlike lego the TSIG algorithm
+// defaults to hmac-sha256, as generated by tsig-keygen.
+func newRFC2136Provider(credentials Credentials) (challenge.Provider, error) {
+	config := &rfc2136.Config{
+		Nameserver:         credentials.Get(rfc2136.EnvNameserver),
+		TSIGAlgorithm:      credentials.Get(rfc2136.EnvTSIGAlgorithm),
+		TSIGKey:            credentials.Get(rfc2136.EnvTSIGKey),
+		TSIGSecret:         credentials.Get(rfc2136.EnvTSIGSecret),
+		TTL:                credentials.Int(rfc2136.EnvTTL, dns01.DefaultTTL),
+		PropagationTimeout: credentials.Seconds(rfc2136.EnvPropagationTimeout, 60*time.Second),
+		PollingInterval:    credentials.Seconds(rfc2136.EnvPollingInterval, 2*time.Second),
+		SequenceInterval:   credentials.Seconds(rfc2136.EnvSequenceInterval, dns01.DefaultPropagationTimeout),
+		DNSTimeout:         credentials.Seconds(rfc2136.EnvDNSTimeout, 10*time.Second),
+	}
+	if config.TSIGAlgorithm == "" {
+		config.TSIGAlgorithm = dns.HmacSHA256
+	}
+	config.TSIGAlgorithm = dns.Fqdn(config.TSIGAlgorithm)
+	if config.TSIGKey != "" {
+		config.TSIGKey = dns.Fqdn(config.TSIGKey)
+	}
+	return rfc2136.NewDNSProviderConfig(config)
+}
+
+var execCredentialNames = []string{
+	exec.EnvPath,
+	exec.EnvMode,
+	exec.EnvPropagationTimeout,
+	exec.EnvPollingInterval,
+	exec.EnvSequenceInterval,
+}
+
+// newExecProvider runs EXEC_PATH with present or cleanup, the record name and value
+// (or the domain, token and key authorization if EXEC_MODE is RAW).
+func newExecProvider(credentials Credentials) (challenge.Provider, error) {
+	config := &exec.Config{
+		Program:            credentials.Get(exec.EnvPath),
+		Mode:               credentials.Get(exec.EnvMode),
+		PropagationTimeout: credentials.Seconds(exec.EnvPropagationTimeout, dns01.DefaultPropagationTimeout),
+		PollingInterval:    credentials.Seconds(exec.EnvPollingInterval, dns01.DefaultPollingInterval),
+		SequenceInterval:   credentials.Seconds(exec.EnvSequenceInterval, dns01.DefaultPropagationTimeout),
+	}
+	if config.Program == "" {
+		return nil, E.New("exec: missing ", exec.EnvPath)
+	}
+	return exec.NewDNSProviderConfig(config)
+}
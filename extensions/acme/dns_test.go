@@ -0,0 +1,118 @@
+package acme
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-acme/lego/v4/providers/dns/rfc2136"
+)
+
+func TestCredentialsEnvironment(t *testing.T) {
+	t.Setenv(rfc2136.EnvTSIGKey, "from-env")
+	t.Setenv(rfc2136.EnvNameserver, "192.0.2.1:53")
+	t.Setenv("SSL_CERT_FILE", filepath.Join(t.TempDir(), "missing.pem"))
+
+	credentials, err := (&Settings{
+		DNSProvider:    "rfc2136",
+		DNSCredentials: Credentials{rfc2136.EnvNameserver: "127.0.0.1:53"},
+	}).dnsCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := credentials.Get(rfc2136.EnvTSIGKey); value != "" {
+		t.Fatal("environment read without opt-in: ", value)
+	}
+
+	credentials, err = (&Settings{
+		DNSProvider:           "rfc2136",
+		DNSCredentials:        Credentials{rfc2136.EnvNameserver: "127.0.0.1:53"},
+		DNSCredentialsFromEnv: true,
+	}).dnsCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := credentials.Get(rfc2136.EnvTSIGKey); value != "from-env" {
+		t.Fatal("expected value from environment, got ", value)
+	}
+	if value := credentials.Get(rfc2136.EnvNameserver); value != "127.0.0.1:53" {
+		t.Fatal("expected configured value to take precedence, got ", value)
+	}
+	if value, loaded := credentials["SSL_CERT"]; loaded {
+		t.Fatal("unrelated environment read: ", value)
+	}
+
+	credentials, err = (&Settings{DNSProvider: "rfc2136"}).dnsCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := credentials.Get(rfc2136.EnvNameserver); value != "192.0.2.1:53" {
+		t.Fatal("expected environment fallback without credentials, got ", value)
+	}
+}
+
+func TestCredentialsEnvironmentFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	err := os.WriteFile(path, []byte("from-file\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(rfc2136.EnvTSIGSecret+"_FILE", path)
+
+	credentials, err := (&Settings{DNSProvider: "rfc2136"}).dnsCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := credentials.Get(rfc2136.EnvTSIGSecret); value != "from-file" {
+		t.Fatal("expected value from file, got ", value)
+	}
+}
+
+func TestCredentialsResolveFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	err := os.WriteFile(path, []byte("from-file\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var settings Settings
+	err = json.Unmarshal([]byte(`{
+		"dns_env": {"TEST_DNS_TOKEN": "legacy", "TEST_DNS_TTL": 60, "TEST_DNS_KEY_FILE": "`+path+`"},
+		"dns_credentials": {"TEST_DNS_TOKEN": "current"}
+	}`), &settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials, err := settings.dnsCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := credentials.Get("TEST_DNS_KEY"); value != "from-file" {
+		t.Fatal("expected value from file, got ", value)
+	}
+	if value := credentials.Get("TEST_DNS_TOKEN"); value != "current" {
+		t.Fatal("expected dns_credentials to take precedence, got ", value)
+	}
+	if value := credentials.Get("TEST_DNS_TTL"); value != "60" {
+		t.Fatal("expected number from dns_env, got ", value)
+	}
+}
+
+func TestSetupEnvironment(t *testing.T) {
+	t.Setenv("TEST_DNS_TOKEN", "")
+	t.Setenv("TEST_DNS_TTL", "")
+	settings := &Settings{DNSEnv: &JSONMap{Data: map[string]any{
+		"TEST_DNS_TOKEN": "legacy",
+		"TEST_DNS_TTL":   float64(60),
+	}}}
+	err := settings.SetupEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := os.Getenv("TEST_DNS_TOKEN"); value != "legacy" {
+		t.Fatal("expected value in environment, got ", value)
+	}
+	if value := os.Getenv("TEST_DNS_TTL"); value != "60" {
+		t.Fatal("expected number in environment, got ", value)
+	}
+}
@@ -0,0 +1,60 @@
+package acme
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+
+	"github.com/sagernet/sing/common"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+func isWildcard(domain string) bool {
+	return strings.HasPrefix(domain, "*.")
+}
+
+// sortDomains lowercases, deduplicates and sorts domains, so that the same set of
+// names always maps to the same certificate.
+func sortDomains(domains []string) []string {
+	var sorted []string
+	for _, domain := range domains {
+		domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if domain != "" && !common.Contains(sorted, domain) {
+			sorted = append(sorted, domain)
+		}
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func normalizeDomains(domains []string) ([]string, error) {
+	sorted := sortDomains(domains)
+	if len(sorted) == 0 {
+		return nil, E.New("acme: empty domain name")
+	}
+	for _, domain := range sorted {
+		if strings.Contains(strings.TrimPrefix(domain, "*."), "*") {
+			return nil, E.New("acme: bad wildcard domain ", domain)
+		} else if strings.ContainsAny(domain, "/\\ ") {
+			return nil, E.New("acme: bad domain ", domain)
+		}
+	}
+	return sorted, nil
+}
+
+// StorageKey returns the file name prefix used to store the certificate for domains.
+// A single name keeps the original layout (with * replaced by _wildcard), several names
+// are stored under the first one plus a hash of the full list.
+func StorageKey(domains []string) string {
+	domains = sortDomains(domains)
+	if len(domains) == 0 {
+		return ""
+	}
+	key := strings.Replace(domains[0], "*", "_wildcard", 1)
+	if len(domains) == 1 {
+		return key
+	}
+	hash := sha256.Sum256([]byte(strings.Join(domains, ",")))
+	return key + "+" + hex.EncodeToString(hash[:4])
+}
@@ -0,0 +1,104 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+// ParseKeyType parses one of ec256, ec384, rsa2048 and rsa4096, an empty name
+// returns the RSA-4096 default.
+func ParseKeyType(name string) (certcrypto.KeyType, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return certcrypto.RSA4096, nil
+	case "ec256", "p256":
+		return certcrypto.EC256, nil
+	case "ec384", "p384":
+		return certcrypto.EC384, nil
+	case "rsa2048":
+		return certcrypto.RSA2048, nil
+	case "rsa4096":
+		return certcrypto.RSA4096, nil
+	default:
+		return "", E.New("acme: unknown key type ", name)
+	}
+}
+
+func isECKeyType(keyType certcrypto.KeyType) bool {
+	return keyType == certcrypto.EC256 || keyType == certcrypto.EC384
+}
+
+// alternativeKeyType returns the key type of the second certificate in dual mode.
+func alternativeKeyType(keyType certcrypto.KeyType) certcrypto.KeyType {
+	if isECKeyType(keyType) {
+		return certcrypto.RSA2048
+	}
+	return certcrypto.EC256
+}
+
+func keyTypeOf(privateKey crypto.PrivateKey) certcrypto.KeyType {
+	switch key := privateKey.(type) {
+	case *ecdsa.PrivateKey:
+		switch key.Curve.Params().BitSize {
+		case 256:
+			return certcrypto.EC256
+		case 384:
+			return certcrypto.EC384
+		}
+	case *rsa.PrivateKey:
+		switch key.N.BitLen() {
+		case 2048:
+			return certcrypto.RSA2048
+		case 4096:
+			return certcrypto.RSA4096
+		case 8192:
+			return certcrypto.RSA8192
+		}
+	}
+	return ""
+}
+
+// ChooseCertificate returns the first certificate supported by the client, preferring
+// ECDSA ones, or the first certificate if none matches.
+func ChooseCertificate(hello *tls.ClientHelloInfo, certificates []*tls.Certificate) *tls.Certificate {
+	if len(certificates) == 0 {
+		return nil
+	}
+	for _, preferEC := range []bool{true, false} {
+		for _, certificate := range certificates {
+			_, isEC := certificate.PrivateKey.(*ecdsa.PrivateKey)
+			if isEC == preferEC && hello.SupportsCertificate(certificate) == nil {
+				return certificate
+			}
+		}
+	}
+	return certificates[0]
+}
+
+func parsePrivateKey(content []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, E.New("acme: bad private key")
+	}
+	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return privateKey.(crypto.PrivateKey), nil
+}
+
+func encodePrivateKey(privateKey crypto.PrivateKey) ([]byte, error) {
+	pkcsBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcsBytes}), nil
+}
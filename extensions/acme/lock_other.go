@@ -0,0 +1,23 @@
+//go:build !(linux || darwin || freebsd || openbsd || netbsd || dragonfly)
+
+package acme
+
+import (
+	"os"
+	"sync"
+)
+
+// without flock only goroutines of this process are excluded
+var fileLocks sync.Map
+
+func lockFile(file *os.File) error {
+	lock, _ := fileLocks.LoadOrStore(file.Name(), new(sync.Mutex))
+	lock.(*sync.Mutex).Lock()
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	lock, _ := fileLocks.Load(file.Name())
+	lock.(*sync.Mutex).Unlock()
+	return nil
+}
@@ -0,0 +1,147 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+var certificateExtensions = []string{".crt", ".key", ".json", ".ocsp"}
+
+type CertificateInfo struct {
+	Name      string
+	Domains   []string
+	Issuer    string
+	KeyType   certcrypto.KeyType
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// ListCertificates returns all certificates in the storage.
+func (c *CertificateManager) ListCertificates() ([]CertificateInfo, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	names, err := c.storage.List()
+	if err != nil {
+		return nil, err
+	}
+	var certificates []CertificateInfo
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".crt") {
+			continue
+		}
+		content, err := c.storage.Load(name)
+		if err != nil {
+			return nil, err
+		}
+		chain, err := certcrypto.ParsePEMBundle(content)
+		if err != nil {
+			return nil, E.Cause(err, "acme: parse ", name)
+		}
+		leaf := chain[0]
+		info := CertificateInfo{
+			Name:      strings.TrimSuffix(name, ".crt"),
+			Domains:   leaf.DNSNames,
+			Issuer:    leaf.Issuer.CommonName,
+			NotBefore: leaf.NotBefore,
+			NotAfter:  leaf.NotAfter,
+		}
+		switch key := leaf.PublicKey.(type) {
+		case *ecdsa.PublicKey:
+			info.KeyType = certcrypto.KeyType("P" + key.Curve.Params().Name[2:])
+		case *rsa.PublicKey:
+			info.KeyType = certcrypto.KeyType(strconv.Itoa(key.N.BitLen()))
+		}
+		certificates = append(certificates, info)
+	}
+	return certificates, nil
+}
+
+// RenewForDomains renews the certificates for domains regardless of their expiry.
+func (c *CertificateManager) RenewForDomains(domains []string) ([]*tls.Certificate, error) {
+	return c.getKeyPairs(domains, true)
+}
+
+// RevokeForDomains revokes the stored certificates for domains with an RFC 5280 reason
+// code and deletes them.
+func (c *CertificateManager) RevokeForDomains(domains []string, reason uint) error {
+	if c.err != nil {
+		return c.err
+	}
+	key := StorageKey(domains)
+	names := c.storedNames(key)
+	if len(names) == 0 {
+		return E.New("acme: no certificate for ", strings.Join(domains, ", "))
+	}
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		content, err := c.storage.Load(name + ".crt")
+		if err != nil {
+			return err
+		}
+		err = client.Certificate.RevokeWithReason(content, &reason)
+		if err != nil {
+			return E.Cause(err, "acme: revoke ", name)
+		}
+	}
+	return c.DeleteForDomains(domains)
+}
+
+// DeleteForDomains removes the certificates for domains, including the private keys.
+func (c *CertificateManager) DeleteForDomains(domains []string) error {
+	if c.err != nil {
+		return c.err
+	}
+	key := StorageKey(domains)
+	names := c.storedNames(key)
+	if len(names) == 0 {
+		return E.New("acme: no certificate for ", strings.Join(domains, ", "))
+	}
+	for _, name := range names {
+		unlock, err := c.storage.Lock(name)
+		if err != nil {
+			return err
+		}
+		for _, extension := range certificateExtensions {
+			err = c.storage.Delete(name + extension)
+			if err != nil {
+				break
+			}
+		}
+		unlock()
+		if err != nil {
+			return err
+		}
+	}
+	c.access.Lock()
+	delete(c.certificates, key)
+	delete(c.renewals, key)
+	for domain, it := range c.names {
+		if it == key {
+			delete(c.names, domain)
+		}
+	}
+	c.access.Unlock()
+	return nil
+}
+
+// storedNames returns the storage names of existing certificates of key in any key type.
+func (c *CertificateManager) storedNames(key string) []string {
+	var names []string
+	for _, name := range []string{key, key + ".ecdsa", key + ".rsa"} {
+		if c.storage.Exists(name + ".crt") {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,164 @@
+package acme
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	E "github.com/sagernet/sing/common/exceptions"
+	"golang.org/x/crypto/ocsp"
+)
+
+const ocspRetry = time.Hour
+
+// stapleOCSP returns keyPair with the stored OCSP response of name attached, fetching a
+// new one if it is missing or half way to its next update. revoked reports whether the
+// responder says the certificate is revoked.
+func (c *CertificateManager) stapleOCSP(name string, keyPair *tls.Certificate) (stapled *tls.Certificate, revoked bool) {
+	if c.disableOCSP || len(keyPair.Leaf.OCSPServer) == 0 || len(keyPair.Certificate) < 2 {
+		return keyPair, false
+	}
+	issuer, err := x509.ParseCertificate(keyPair.Certificate[1])
+	if err != nil {
+		return keyPair, false
+	}
+	now := c.clock.Now()
+	var current []byte
+	if staple, err := c.storage.Load(name + ".ocsp"); err == nil {
+		response, err := ocsp.ParseResponseForCert(staple, keyPair.Leaf, issuer)
+		if err == nil && response.Status == ocsp.Good && now.Before(response.NextUpdate) {
+			current = staple
+			if now.Before(responseRefreshTime(response)) {
+				return withStaple(keyPair, staple), false
+			}
+		}
+	}
+	staple, response, err := c.fetchOCSP(keyPair.Leaf, issuer)
+	if err != nil {
+		logger.Warn("fetch ocsp response for ", name, ": ", err)
+		if current != nil {
+			return withStaple(keyPair, current), false
+		}
+		return keyPair, false
+	}
+	switch response.Status {
+	case ocsp.Revoked:
+		return keyPair, true
+	case ocsp.Good:
+	default:
+		return keyPair, false
+	}
+	err = c.storage.Store(name+".ocsp", staple)
+	if err != nil {
+		logger.Warn("store ocsp response for ", name, ": ", err)
+	}
+	return withStaple(keyPair, staple), false
+}
+
+// refreshOCSP staples fresh OCSP responses to the current certificates of key.
+func (c *CertificateManager) refreshOCSP(key string, domains []string) {
+	c.access.Lock()
+	current := c.certificates[key]
+	c.access.Unlock()
+	names := c.certificateNames(key)
+	if len(current) != len(names) {
+		return
+	}
+	keyPairs := make([]*tls.Certificate, 0, len(current))
+	for index, it := range names {
+		keyPair, revoked := c.stapleOCSP(it.name, current[index])
+		if revoked {
+			logger.Error("certificate ", it.name, " is revoked")
+			// renew with a new key on the next run
+			err := c.deleteRevoked(it.name, current[index].Leaf)
+			if err != nil {
+				logger.Error("delete revoked certificate ", it.name, ": ", err)
+			}
+			c.access.Lock()
+			if renewal := c.renewals[key]; renewal != nil {
+				renewal.due = c.clock.Now()
+			}
+			c.access.Unlock()
+		}
+		keyPairs = append(keyPairs, keyPair)
+	}
+	c.access.Lock()
+	latest := c.certificates[key]
+	c.access.Unlock()
+	// skip if renewed meanwhile
+	if len(latest) == 0 || latest[0].Leaf != current[0].Leaf {
+		return
+	}
+	c.update(key, domains, keyPairs)
+}
+
+// ocspRefreshTime returns when the first of the stapled responses should be refreshed,
+// zero if no certificate needs stapling.
+func (c *CertificateManager) ocspRefreshTime(keyPairs []*tls.Certificate) time.Time {
+	if c.disableOCSP {
+		return time.Time{}
+	}
+	now := c.clock.Now()
+	var refreshAt time.Time
+	for _, keyPair := range keyPairs {
+		if len(keyPair.Leaf.OCSPServer) == 0 {
+			continue
+		}
+		next := now.Add(ocspRetry)
+		if keyPair.OCSPStaple != nil {
+			response, err := ocsp.ParseResponse(keyPair.OCSPStaple, nil)
+			if err == nil && responseRefreshTime(response).After(now) {
+				next = responseRefreshTime(response)
+			}
+		}
+		if refreshAt.IsZero() || next.Before(refreshAt) {
+			refreshAt = next
+		}
+	}
+	return refreshAt
+}
+
+// responseRefreshTime is half way between the updates of response.
+func responseRefreshTime(response *ocsp.Response) time.Time {
+	if response.NextUpdate.IsZero() {
+		return response.ThisUpdate.Add(ocspRetry)
+	}
+	return response.ThisUpdate.Add(response.NextUpdate.Sub(response.ThisUpdate) / 2)
+}
+
+func withStaple(keyPair *tls.Certificate, staple []byte) *tls.Certificate {
+	stapled := *keyPair
+	stapled.OCSPStaple = staple
+	return &stapled
+}
+
+func (c *CertificateManager) fetchOCSP(leaf *x509.Certificate, issuer *x509.Certificate) ([]byte, *ocsp.Response, error) {
+	request, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := c.httpClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	response, err := client.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(request))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, nil, E.New("HTTP ", response.StatusCode)
+	}
+	staple, err := ioutil.ReadAll(http.MaxBytesReader(nil, response.Body, 1024*1024))
+	if err != nil {
+		return nil, nil, err
+	}
+	parsed, err := ocsp.ParseResponseForCert(staple, leaf, issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	return staple, parsed, nil
+}
@@ -0,0 +1,306 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// testResponder is a local OCSP responder for certificates issued by its CA.
+type testResponder struct {
+	*httptest.Server
+	clock  *fakeClock
+	issuer *x509.Certificate
+	key    crypto.Signer
+
+	access   sync.Mutex
+	status   int
+	revoked  map[string]bool
+	requests int
+}
+
+func newTestResponder(t *testing.T, clock *fakeClock) *testResponder {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             clock.Now().Add(-time.Hour),
+		NotAfter:              clock.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	content, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, _ := x509.ParseCertificate(content)
+	responder := &testResponder{clock: clock, issuer: issuer, key: key, status: ocsp.Good}
+	responder.Server = httptest.NewServer(responder)
+	t.Cleanup(responder.Close)
+	return responder
+}
+
+func (r *testResponder) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	content, _ := io.ReadAll(request.Body)
+	ocspRequest, err := ocsp.ParseRequest(content)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.access.Lock()
+	r.requests++
+	status := r.status
+	if r.revoked[ocspRequest.SerialNumber.String()] {
+		status = ocsp.Revoked
+	}
+	r.access.Unlock()
+	now := r.clock.Now()
+	template := ocsp.Response{
+		Status:       status,
+		SerialNumber: ocspRequest.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(4 * 24 * time.Hour),
+	}
+	if status == ocsp.Revoked {
+		template.RevokedAt = now
+	}
+	response, err := ocsp.CreateResponse(r.issuer, r.issuer, template, r.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(response)
+}
+
+func (r *testResponder) setStatus(status int) {
+	r.access.Lock()
+	r.status = status
+	r.access.Unlock()
+}
+
+// revoke reports the certificate with serial as revoked regardless of the status.
+func (r *testResponder) revoke(serial *big.Int) {
+	r.access.Lock()
+	if r.revoked == nil {
+		r.revoked = make(map[string]bool)
+	}
+	r.revoked[serial.String()] = true
+	r.access.Unlock()
+}
+
+func (r *testResponder) requestCount() int {
+	r.access.Lock()
+	defer r.access.Unlock()
+	return r.requests
+}
+
+func (r *testResponder) issue(t *testing.T) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    r.clock.Now().Add(-time.Hour),
+		NotAfter:     r.clock.Now().Add(90 * 24 * time.Hour),
+		OCSPServer:   []string{r.URL},
+	}
+	content, err := x509.CreateCertificate(rand.Reader, template, r.issuer, key.Public(), r.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(content)
+	return &tls.Certificate{
+		Certificate: [][]byte{content, r.issuer.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+}
+
+func TestStapleOCSP(t *testing.T) {
+	manager, clock := newTestManager(t, nil)
+	responder := newTestResponder(t, clock)
+	keyPair := responder.issue(t)
+
+	stapled, revoked := manager.stapleOCSP("example.com", keyPair)
+	if revoked || stapled.OCSPStaple == nil {
+		t.Fatal("expected a good staple")
+	}
+	if !manager.storage.Exists("example.com.ocsp") {
+		t.Fatal("staple not stored")
+	}
+	refreshAt := manager.ocspRefreshTime([]*tls.Certificate{stapled})
+	if !refreshAt.Equal(clock.Now().Add(2 * 24 * time.Hour)) {
+		t.Fatal("unexpected refresh time ", refreshAt)
+	}
+
+	clock.Advance(24 * time.Hour)
+	manager.stapleOCSP("example.com", keyPair)
+	if responder.requestCount() != 1 {
+		t.Fatal("expected stored staple to be used")
+	}
+
+	clock.Advance(24 * time.Hour)
+	restapled, _ := manager.stapleOCSP("example.com", keyPair)
+	if responder.requestCount() != 2 {
+		t.Fatal("expected staple to be refreshed half way to its next update")
+	}
+	response, err := ocsp.ParseResponse(restapled.OCSPStaple, nil)
+	if err != nil || !response.ThisUpdate.Equal(clock.Now()) {
+		t.Fatal("expected refreshed staple, got ", err)
+	}
+}
+
+func TestStapleOCSPResponderDown(t *testing.T) {
+	manager, clock := newTestManager(t, nil)
+	responder := newTestResponder(t, clock)
+	keyPair := responder.issue(t)
+	stapled, _ := manager.stapleOCSP("example.com", keyPair)
+
+	responder.Close()
+	clock.Advance(3 * 24 * time.Hour)
+	current, revoked := manager.stapleOCSP("example.com", keyPair)
+	if revoked || string(current.OCSPStaple) != string(stapled.OCSPStaple) {
+		t.Fatal("expected the current staple to be kept")
+	}
+	clock.Advance(2 * 24 * time.Hour)
+	expired, _ := manager.stapleOCSP("example.com", keyPair)
+	if expired.OCSPStaple != nil {
+		t.Fatal("expected expired staple to be dropped")
+	}
+	if refreshAt := manager.ocspRefreshTime([]*tls.Certificate{expired}); !refreshAt.Equal(clock.Now().Add(ocspRetry)) {
+		t.Fatal("expected retry in ", ocspRetry, ", got ", refreshAt)
+	}
+}
+
+func TestStapleOCSPRevoked(t *testing.T) {
+	manager, clock := newTestManager(t, nil)
+	responder := newTestResponder(t, clock)
+	responder.setStatus(ocsp.Revoked)
+	keyPair := responder.issue(t)
+	stapled, revoked := manager.stapleOCSP("example.com", keyPair)
+	if !revoked || stapled.OCSPStaple != nil {
+		t.Fatal("expected revoked certificate")
+	}
+	if manager.storage.Exists("example.com.ocsp") {
+		t.Fatal("revoked response stored")
+	}
+}
+
+func storeTestKeyPair(t *testing.T, storage Storage, name string, keyPair *tls.Certificate) {
+	privateKey, err := x509.MarshalPKCS8PrivateKey(keyPair.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.Store(name+".crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: keyPair.Certificate[0]}))
+	storage.Store(name+".key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateKey}))
+	storage.Store(name+".ocsp", []byte("staple"))
+}
+
+func TestDeleteRevoked(t *testing.T) {
+	manager, clock := newTestManager(t, nil)
+	responder := newTestResponder(t, clock)
+	revoked := responder.issue(t)
+	storeTestKeyPair(t, manager.storage, "example.com", revoked)
+
+	// another process holds the lock while replacing the certificate
+	unlock, err := manager.storage.Lock("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- manager.deleteRevoked("example.com", revoked.Leaf)
+	}()
+	select {
+	case <-done:
+		t.Fatal("deleted without holding the storage lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+	replaced := responder.issue(t)
+	storeTestKeyPair(t, manager.storage, "example.com", replaced)
+	unlock()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !manager.storage.Exists("example.com.crt") || !manager.storage.Exists("example.com.key") {
+		t.Fatal("replaced certificate deleted")
+	}
+
+	err = manager.deleteRevoked("example.com", replaced.Leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, extension := range certificateExtensions {
+		if manager.storage.Exists("example.com" + extension) {
+			t.Fatal("revoked ", extension, " not deleted")
+		}
+	}
+}
+
+func TestRefreshOCSPRevoked(t *testing.T) {
+	address := freeAddress(t)
+	manager, clock, server := newTestACMEManager(t, &Settings{
+		HTTPChallenge: &ChallengeSettings{Listen: address},
+	})
+	responder := newTestResponder(t, clock)
+	server.httpAddress = address
+	server.issuer, server.key = responder.issuer, responder.key
+	server.ocspServer = []string{responder.URL}
+
+	keyPair, err := manager.GetKeyPair("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyPair.OCSPStaple == nil {
+		t.Fatal("expected a good staple")
+	}
+	updated := make(chan *tls.Certificate, 16)
+	manager.RegisterUpdateListener("example.com", func(certificate *tls.Certificate) {
+		updated <- certificate
+	})
+
+	// revoked while refreshing the staple
+	responder.revoke(keyPair.Leaf.SerialNumber)
+	clock.waitTimer(t)
+	clock.Advance(2 * 24 * time.Hour)
+	for {
+		select {
+		case certificate := <-updated:
+			if certificate.Leaf.Equal(keyPair.Leaf) {
+				continue
+			}
+			if certificate.Leaf.PublicKey.(*ecdsa.PublicKey).Equal(keyPair.Leaf.PublicKey) {
+				t.Fatal("revoked key reused")
+			}
+			if certificate.OCSPStaple == nil {
+				t.Fatal("expected the new certificate to be stapled")
+			}
+			return
+		case <-time.After(10 * time.Second):
+			t.Fatal("timeout waiting for a new certificate")
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package acme
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"math/rand"
+	"time"
+)
+
+const (
+	DefaultRenewFraction = 2.0 / 3
+
+	renewRetryMin    = time.Minute
+	renewRetryMax    = 24 * time.Hour
+	renewCheckMax    = 24 * time.Hour
+	renewalInfoRetry = 6 * time.Hour
+)
+
+type clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) (<-chan time.Time, func() bool)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	timer := time.NewTimer(d)
+	return timer.C, timer.Stop
+}
+
+type renewal struct {
+	leaf     *x509.Certificate
+	due      time.Time
+	failures int
+	// next ACME Renewal Information poll, zero if not supported
+	checkAt time.Time
+	// next OCSP response refresh, zero if not stapled
+	ocspAt time.Time
+}
+
+// renewTime returns when leaf should be renewed according to the configured fraction of
+// its lifetime, moved earlier by up to 5% of the lifetime so that certificates issued
+// together are not all renewed at once.
+func (c *CertificateManager) renewTime(leaf *x509.Certificate, jitter bool) time.Time {
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	renewAt := leaf.NotBefore.Add(time.Duration(float64(lifetime) * c.renewFraction))
+	if jitter && lifetime/20 > 0 {
+		renewAt = renewAt.Add(-time.Duration(rand.Int63n(int64(lifetime / 20))))
+	}
+	return renewAt
+}
+
+// schedule records the current certificate of key, it is called with access held.
+func (c *CertificateManager) schedule(key string, keyPairs []*tls.Certificate) {
+	leaf := keyPairs[0].Leaf
+	it := c.renewals[key]
+	if it == nil || it.leaf == nil || !it.leaf.Equal(leaf) {
+		it = &renewal{
+			leaf:    leaf,
+			due:     c.renewTime(leaf, true),
+			checkAt: c.clock.Now(),
+		}
+		c.renewals[key] = it
+	}
+	it.ocspAt = c.ocspRefreshTime(keyPairs)
+	select {
+	case c.renewWake <- struct{}{}:
+	default:
+	}
+}
+
+// start is called with access held. A loop stopped before may still be renewing and
+// waiting for access, so the new loop waits for it to exit, not start itself.
+func (c *CertificateManager) start() {
+	c.renewClose = make(chan struct{})
+	c.renewDone = make(chan struct{})
+	go c.loopRenew(c.renewStopped, c.renewClose, c.renewDone)
+}
+
+// stop is called with access held and returns the channel closed once the loop exits.
+func (c *CertificateManager) stop() chan struct{} {
+	if c.renewClose == nil {
+		return nil
+	}
+	close(c.renewClose)
+	renewDone := c.renewDone
+	c.renewClose = nil
+	c.renewDone = nil
+	c.renewStopped = renewDone
+	return renewDone
+}
+
+// Close stops background renewal.
+func (c *CertificateManager) Close() error {
+	c.access.Lock()
+	renewDone := c.stop()
+	c.access.Unlock()
+	if renewDone != nil {
+		<-renewDone
+	}
+	return nil
+}
+
+func (c *CertificateManager) loopRenew(previousDone chan struct{}, renewClose chan struct{}, renewDone chan struct{}) {
+	defer close(renewDone)
+	if previousDone != nil {
+		<-previousDone
+	}
+	for {
+		select {
+		case <-renewClose:
+			return
+		default:
+		}
+		c.checkRenewalInfo(renewClose)
+		next := c.renewDue(renewClose)
+		timer, stop := c.clock.NewTimer(next)
+		select {
+		case <-timer:
+		case <-c.renewWake:
+			stop()
+		case <-renewClose:
+			stop()
+			return
+		}
+	}
+}
+
+// checkRenewalInfo moves the renewal of certificates into the window suggested by
+// the ACME server, if it supports ACME Renewal Information.
+func (c *CertificateManager) checkRenewalInfo(renewClose chan struct{}) {
+	now := c.clock.Now()
+	c.access.Lock()
+	var pending []*renewal
+	for key := range c.domains {
+		it := c.renewals[key]
+		if it != nil && !it.checkAt.IsZero() && !it.checkAt.After(now) {
+			pending = append(pending, it)
+		}
+	}
+	c.access.Unlock()
+	for _, it := range pending {
+		select {
+		case <-renewClose:
+			return
+		default:
+		}
+		start, end, retryAfter, err := c.fetchRenewalInfo(it.leaf)
+		c.access.Lock()
+		if err == errRenewalInfoUnsupported {
+			it.checkAt = time.Time{}
+		} else if err != nil {
+			logger.Debug("fetch renewal information for ", it.leaf.Subject.CommonName, ": ", err)
+			it.checkAt = now.Add(renewalInfoRetry)
+		} else {
+			if retryAfter <= 0 {
+				retryAfter = renewalInfoRetry
+			}
+			it.checkAt = now.Add(retryAfter)
+			if it.failures == 0 {
+				due := start
+				if window := end.Sub(start); window > 0 {
+					due = due.Add(time.Duration(rand.Int63n(int64(window))))
+				}
+				it.due = due
+			}
+		}
+		c.access.Unlock()
+	}
+}
+
+// renewDue renews every certificate that is due and returns the time until the next
+// renewal or renewal information check.
+func (c *CertificateManager) renewDue(renewClose chan struct{}) time.Duration {
+	now := c.clock.Now()
+	c.access.Lock()
+	due := make(map[string][]string)
+	next := now.Add(renewCheckMax)
+	staple := make(map[string][]string)
+	for key, domains := range c.domains {
+		it := c.renewals[key]
+		if it == nil || !it.due.After(now) {
+			due[key] = domains
+			continue
+		}
+		if !it.ocspAt.IsZero() && !it.ocspAt.After(now) {
+			staple[key] = domains
+		}
+		if it.due.Before(next) {
+			next = it.due
+		}
+		if !it.ocspAt.IsZero() && it.ocspAt.Before(next) {
+			next = it.ocspAt
+		}
+		if !it.checkAt.IsZero() && it.checkAt.Before(next) {
+			next = it.checkAt
+		}
+	}
+	c.access.Unlock()
+
+	for key, domains := range staple {
+		c.refreshOCSP(key, domains)
+	}
+
+	for key, domains := range due {
+		select {
+		case <-renewClose:
+			return 0
+		default:
+		}
+		c.access.Lock()
+		// certificates which were never loaded are only obtained if missing or expiring
+		force := c.renewals[key] != nil
+		c.access.Unlock()
+		_, err := c.getKeyPairs(domains, force)
+		if err == nil {
+			continue
+		}
+		c.access.Lock()
+		it := c.renewals[key]
+		if it == nil {
+			it = &renewal{}
+			c.renewals[key] = it
+		}
+		it.failures++
+		retry := renewRetryMax
+		if it.failures < 12 {
+			retry = renewRetryMin << (it.failures - 1)
+			if retry > renewRetryMax {
+				retry = renewRetryMax
+			}
+		}
+		it.due = now.Add(retry)
+		if it.due.Before(next) {
+			next = it.due
+		}
+		c.access.Unlock()
+		logger.Error("renew certificate for ", domains, " failed (retry in ", retry, "): ", err)
+	}
+	return next.Sub(now)
+}
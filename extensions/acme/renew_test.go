@@ -0,0 +1,241 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLeaf(t *testing.T, notBefore time.Time, lifetime time.Duration, authorityKeyID []byte) *x509.Certificate {
+	t.Helper()
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(0x1234),
+		Subject:        pkix.Name{CommonName: "example.com"},
+		DNSNames:       []string{"example.com"},
+		NotBefore:      notBefore,
+		NotAfter:       notBefore.Add(lifetime),
+		AuthorityKeyId: authorityKeyID,
+	}
+	content, err := x509.CreateCertificate(rand.Reader, template, template, privateKey.Public(), privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf
+}
+
+func TestRenewTime(t *testing.T) {
+	manager, clock := newTestManager(t, &Settings{RenewFraction: 0.5})
+	lifetime := 90 * 24 * time.Hour
+	leaf := newTestLeaf(t, clock.Now(), lifetime, nil)
+	renewAt := clock.Now().Add(lifetime / 2)
+	if !manager.renewTime(leaf, false).Equal(renewAt) {
+		t.Fatal("unexpected renew time ", manager.renewTime(leaf, false))
+	}
+	for i := 0; i < 100; i++ {
+		jittered := manager.renewTime(leaf, true)
+		if jittered.After(renewAt) || jittered.Before(renewAt.Add(-lifetime/20)) {
+			t.Fatal("jitter out of range: ", jittered)
+		}
+	}
+	manager, _ = newTestManager(t, nil)
+	if !manager.renewTime(leaf, false).Equal(clock.Now().Add(60 * 24 * time.Hour)) {
+		t.Fatal("unexpected default renew time ", manager.renewTime(leaf, false))
+	}
+}
+
+func TestRenewBackoff(t *testing.T) {
+	manager, clock := newTestManager(t, nil)
+	key := StorageKey([]string{"example.com"})
+	manager.domains[key] = []string{"example.com"}
+	manager.renewals[key] = &renewal{
+		leaf: newTestLeaf(t, clock.Now().Add(-60*24*time.Hour), 90*24*time.Hour, nil),
+		due:  clock.Now(),
+	}
+	renewClose := make(chan struct{})
+	for i, expected := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute} {
+		next := manager.renewDue(renewClose)
+		if next != expected {
+			t.Fatal("attempt ", i+1, ": expected retry in ", expected, ", got ", next)
+		}
+		if manager.renewals[key].failures != i+1 {
+			t.Fatal("unexpected failures ", manager.renewals[key].failures)
+		}
+		if next := manager.renewDue(renewClose); next != expected {
+			t.Fatal("renewed before backoff elapsed")
+		}
+		clock.Advance(expected)
+	}
+	manager.renewals[key].failures = 20
+	if next := manager.renewDue(renewClose); next != renewRetryMax {
+		t.Fatal("expected retry capped at ", renewRetryMax, ", got ", next)
+	}
+}
+
+func newRenewalInfoServer(t *testing.T, start time.Time, end time.Time) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/directory":
+			json.NewEncoder(w).Encode(map[string]string{"renewalInfo": server.URL + "/renewal-info"})
+		case strings.HasPrefix(r.URL.Path, "/renewal-info/"):
+			var info renewalInfo
+			info.SuggestedWindow.Start = start
+			info.SuggestedWindow.End = end
+			w.Header().Set("Retry-After", "3600")
+			json.NewEncoder(w).Encode(info)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRenewalInfoWindow(t *testing.T) {
+	start := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	end := start.Add(48 * time.Hour)
+	server := newRenewalInfoServer(t, start, end)
+	manager, clock := newTestManager(t, &Settings{DirectoryURL: server.URL + "/directory"})
+	key := StorageKey([]string{"example.com"})
+	leaf := newTestLeaf(t, clock.Now(), 90*24*time.Hour, []byte{1, 2, 3, 4})
+	it := &renewal{leaf: leaf, due: manager.renewTime(leaf, false), checkAt: clock.Now()}
+	manager.domains[key] = []string{"example.com"}
+	manager.renewals[key] = it
+
+	manager.checkRenewalInfo(make(chan struct{}))
+	if it.due.Before(start) || !it.due.Before(end) {
+		t.Fatal("due ", it.due, " outside suggested window")
+	}
+	if !it.checkAt.Equal(clock.Now().Add(time.Hour)) {
+		t.Fatal("unexpected next check ", it.checkAt)
+	}
+
+	// a failed renewal keeps its backoff
+	it.failures = 1
+	it.due = clock.Now().Add(time.Minute)
+	it.checkAt = clock.Now()
+	manager.checkRenewalInfo(make(chan struct{}))
+	if !it.due.Equal(clock.Now().Add(time.Minute)) {
+		t.Fatal("backoff replaced by suggested window")
+	}
+}
+
+func TestRenewalInfoUnsupported(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{})
+	}))
+	defer server.Close()
+	manager, clock := newTestManager(t, &Settings{DirectoryURL: server.URL})
+	key := StorageKey([]string{"example.com"})
+	leaf := newTestLeaf(t, clock.Now(), 90*24*time.Hour, []byte{1, 2, 3, 4})
+	due := manager.renewTime(leaf, false)
+	it := &renewal{leaf: leaf, due: due, checkAt: clock.Now()}
+	manager.domains[key] = []string{"example.com"}
+	manager.renewals[key] = it
+	manager.checkRenewalInfo(make(chan struct{}))
+	if !it.checkAt.IsZero() || !it.due.Equal(due) {
+		t.Fatal("expected renewal information checks to stop")
+	}
+}
+
+func TestRenewLoopClose(t *testing.T) {
+	manager, clock := newTestManager(t, nil)
+	key := StorageKey([]string{"example.com"})
+	leaf := newTestLeaf(t, clock.Now(), 90*24*time.Hour, nil)
+	manager.access.Lock()
+	manager.domains[key] = []string{"example.com"}
+	manager.schedule(key, []*tls.Certificate{{Leaf: leaf}})
+	manager.renewals[key].checkAt = time.Time{}
+	manager.start()
+	manager.access.Unlock()
+	next := clock.waitTimer(t)
+	if next != renewCheckMax {
+		t.Fatal("expected next check in ", renewCheckMax, ", got ", next)
+	}
+
+	manager.access.Lock()
+	previousDone := manager.stop()
+	manager.start()
+	manager.access.Unlock()
+	manager.Close()
+	select {
+	case <-previousDone:
+	default:
+		t.Fatal("Close returned before the stopped loop exited")
+	}
+	manager.access.Lock()
+	running := manager.renewClose != nil
+	manager.access.Unlock()
+	if running {
+		t.Fatal("loop still running after Close")
+	}
+}
+
+func TestRenewDueCertificate(t *testing.T) {
+	address := freeAddress(t)
+	manager, clock, server := newTestACMEManager(t, &Settings{
+		HTTPChallenge: &ChallengeSettings{Listen: address},
+	})
+	server.httpAddress = address
+	keyPair, err := manager.GetKeyPair("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := manager.storage.Load("example.com.crt")
+	updated := make(chan *tls.Certificate, 1)
+	manager.RegisterUpdateListener("example.com", func(certificate *tls.Certificate) {
+		select {
+		case updated <- certificate:
+		default:
+		}
+	})
+
+	clock.waitTimer(t)
+	clock.Advance(60 * 24 * time.Hour)
+	var renewed *tls.Certificate
+	select {
+	case renewed = <-updated:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for renewal")
+	}
+	if renewed.Leaf.Equal(keyPair.Leaf) || !renewed.Leaf.NotBefore.Equal(clock.Now()) {
+		t.Fatal("expected a certificate issued on renewal")
+	}
+	if !renewed.Leaf.PublicKey.(*ecdsa.PublicKey).Equal(keyPair.Leaf.PublicKey) {
+		t.Fatal("expected the key to be kept on renewal")
+	}
+	if server.issuedCount() != 2 {
+		t.Fatal("expected one renewal, got ", server.issuedCount()-1)
+	}
+	if content, _ := manager.storage.Load("example.com.crt"); string(content) == string(stored) {
+		t.Fatal("stored certificate not replaced")
+	}
+	current, err := manager.GetKeyPair("example.com")
+	if err != nil || !current.Leaf.Equal(renewed.Leaf) {
+		t.Fatal("expected the renewed certificate to be served")
+	}
+	manager.access.Lock()
+	due := manager.renewals[StorageKey([]string{"example.com"})].due
+	manager.access.Unlock()
+	if !due.After(clock.Now()) {
+		t.Fatal("renewal not rescheduled for the renewed certificate")
+	}
+}
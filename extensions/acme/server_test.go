@@ -0,0 +1,640 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/challenge/http01"
+	E "github.com/sagernet/sing/common/exceptions"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// testACMEServer is a minimal in-process ACME server in the spirit of Pebble. HTTP-01
+// challenges are validated on httpAddress and TLS-ALPN-01 challenges on tlsAddress
+// instead of the ports of the domain, certificates are issued by issuer valid from the
+// time of clock.
+type testACMEServer struct {
+	*httptest.Server
+	clock       *fakeClock
+	issuer      *x509.Certificate
+	key         crypto.Signer
+	ocspServer  []string
+	httpAddress string
+	tlsAddress  string
+
+	access         sync.Mutex
+	nonce          int
+	nonces         map[string]bool
+	accounts       []*jose.JSONWebKey
+	orders         []*testOrder
+	authorizations []*testAuthorization
+	certificates   [][]byte
+}
+
+type testOrder struct {
+	account        int
+	identifiers    []acme.Identifier
+	authorizations []int
+	certificate    int
+}
+
+type testAuthorization struct {
+	account    int
+	identifier acme.Identifier
+	token      string
+	status     string
+	validated  string
+	err        *acme.ProblemDetails
+}
+
+var testChallengeTypes = []string{"http-01", "tls-alpn-01"}
+
+func newTestACMEServer(t *testing.T) *testACMEServer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test ACME CA"},
+		NotBefore:             now.Add(-10 * 365 * 24 * time.Hour),
+		NotAfter:              now.Add(10 * 365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	content, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, _ := x509.ParseCertificate(content)
+	server := &testACMEServer{
+		clock:  newFakeClock(),
+		issuer: issuer,
+		key:    key,
+		nonces: make(map[string]bool),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/directory", server.directory)
+	mux.HandleFunc("/nonce", server.newNonce)
+	mux.HandleFunc("/account", server.newAccount)
+	mux.HandleFunc("/order", server.newOrder)
+	mux.HandleFunc("/order/", server.getOrder)
+	mux.HandleFunc("/authz/", server.getAuthorization)
+	mux.HandleFunc("/challenge/", server.validate)
+	mux.HandleFunc("/finalize/", server.finalize)
+	mux.HandleFunc("/certificate/", server.getCertificate)
+	server.Server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestACMEManager returns a test manager using a new ACME server, which shares the
+// clock of the manager.
+func newTestACMEManager(t *testing.T, settings *Settings) (*CertificateManager, *fakeClock, *testACMEServer) {
+	t.Helper()
+	server := newTestACMEServer(t)
+	if settings == nil {
+		settings = &Settings{}
+	}
+	settings.DirectoryURL = server.URL + "/directory"
+	manager, clock := newTestManager(t, settings)
+	server.clock = clock
+	return manager, clock, server
+}
+
+func (s *testACMEServer) directory(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, acme.Directory{
+		NewNonceURL:   s.URL + "/nonce",
+		NewAccountURL: s.URL + "/account",
+		NewOrderURL:   s.URL + "/order",
+	})
+}
+
+func (s *testACMEServer) newNonce(w http.ResponseWriter, r *http.Request) {
+	s.addNonce(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *testACMEServer) addNonce(w http.ResponseWriter) {
+	s.access.Lock()
+	s.nonce++
+	nonce := strconv.Itoa(s.nonce)
+	s.nonces[nonce] = true
+	s.access.Unlock()
+	w.Header().Set("Replay-Nonce", nonce)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// verify checks the JWS of a POST request and returns its payload and the account id,
+// -1 with the embedded key for requests not signed by an account.
+func (s *testACMEServer) verify(w http.ResponseWriter, r *http.Request) ([]byte, int, *jose.JSONWebKey, bool) {
+	s.addNonce(w)
+	if r.Method != http.MethodPost {
+		writeProblem(w, http.StatusMethodNotAllowed, "malformed", "expected POST")
+		return nil, 0, nil, false
+	}
+	content, _ := io.ReadAll(r.Body)
+	signed, err := jose.ParseSigned(string(content))
+	if err != nil || len(signed.Signatures) != 1 {
+		writeProblem(w, http.StatusBadRequest, "malformed", "bad JWS")
+		return nil, 0, nil, false
+	}
+	header := signed.Signatures[0].Protected
+	s.access.Lock()
+	validNonce := s.nonces[header.Nonce]
+	delete(s.nonces, header.Nonce)
+	s.access.Unlock()
+	if !validNonce {
+		writeProblem(w, http.StatusBadRequest, "badNonce", "bad nonce")
+		return nil, 0, nil, false
+	}
+	if url, _ := header.ExtraHeaders["url"].(string); url != s.URL+r.URL.Path {
+		writeProblem(w, http.StatusUnauthorized, "unauthorized", "bad url "+url)
+		return nil, 0, nil, false
+	}
+	account := -1
+	key := header.JSONWebKey
+	if key == nil {
+		account, err = strconv.Atoi(strings.TrimPrefix(header.KeyID, s.URL+"/account/"))
+		s.access.Lock()
+		if err == nil && account >= 0 && account < len(s.accounts) {
+			key = s.accounts[account]
+		}
+		s.access.Unlock()
+		if key == nil {
+			writeProblem(w, http.StatusBadRequest, "accountDoesNotExist", "unknown account "+header.KeyID)
+			return nil, 0, nil, false
+		}
+	}
+	payload, err := signed.Verify(key)
+	if err != nil {
+		writeProblem(w, http.StatusUnauthorized, "unauthorized", err.Error())
+		return nil, 0, nil, false
+	}
+	return payload, account, key, true
+}
+
+// verifyAccount is like verify, but requires an account.
+func (s *testACMEServer) verifyAccount(w http.ResponseWriter, r *http.Request) ([]byte, int, bool) {
+	payload, account, _, ok := s.verify(w, r)
+	if ok && account < 0 {
+		writeProblem(w, http.StatusBadRequest, "malformed", "expected kid")
+		return nil, 0, false
+	}
+	return payload, account, ok
+}
+
+func (s *testACMEServer) newAccount(w http.ResponseWriter, r *http.Request) {
+	payload, account, key, ok := s.verify(w, r)
+	if !ok {
+		return
+	}
+	if account >= 0 {
+		writeProblem(w, http.StatusBadRequest, "malformed", "expected jwk")
+		return
+	}
+	var request acme.Account
+	err := json.Unmarshal(payload, &request)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	thumbprint, _ := key.Thumbprint(crypto.SHA256)
+	s.access.Lock()
+	for index, it := range s.accounts {
+		if existing, _ := it.Thumbprint(crypto.SHA256); string(existing) == string(thumbprint) {
+			account = index
+		}
+	}
+	status := http.StatusOK
+	if account < 0 {
+		if request.OnlyReturnExisting {
+			s.access.Unlock()
+			writeProblem(w, http.StatusBadRequest, "accountDoesNotExist", "no account for key")
+			return
+		}
+		account = len(s.accounts)
+		s.accounts = append(s.accounts, key)
+		status = http.StatusCreated
+	}
+	s.access.Unlock()
+	w.Header().Set("Location", s.URL+"/account/"+strconv.Itoa(account))
+	writeJSON(w, status, acme.Account{Status: acme.StatusValid, Contact: request.Contact})
+}
+
+func (s *testACMEServer) newOrder(w http.ResponseWriter, r *http.Request) {
+	payload, account, ok := s.verifyAccount(w, r)
+	if !ok {
+		return
+	}
+	var request acme.Order
+	err := json.Unmarshal(payload, &request)
+	if err != nil || len(request.Identifiers) == 0 {
+		writeProblem(w, http.StatusBadRequest, "malformed", "bad order")
+		return
+	}
+	s.access.Lock()
+	order := &testOrder{account: account, identifiers: request.Identifiers, certificate: -1}
+	for _, identifier := range request.Identifiers {
+		token := make([]byte, 16)
+		rand.Read(token)
+		order.authorizations = append(order.authorizations, len(s.authorizations))
+		s.authorizations = append(s.authorizations, &testAuthorization{
+			account:    account,
+			identifier: acme.Identifier{Type: identifier.Type, Value: strings.ToLower(identifier.Value)},
+			token:      base64.RawURLEncoding.EncodeToString(token),
+			status:     acme.StatusPending,
+		})
+	}
+	id := len(s.orders)
+	s.orders = append(s.orders, order)
+	response := s.orderObject(id)
+	s.access.Unlock()
+	w.Header().Set("Location", s.URL+"/order/"+strconv.Itoa(id))
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// orderObject is called with access held.
+func (s *testACMEServer) orderObject(id int) acme.Order {
+	order := s.orders[id]
+	response := acme.Order{
+		Status:      acme.StatusReady,
+		Expires:     s.clock.Now().Add(24 * time.Hour).Format(time.RFC3339),
+		Identifiers: order.identifiers,
+		Finalize:    s.URL + "/finalize/" + strconv.Itoa(id),
+	}
+	for _, index := range order.authorizations {
+		response.Authorizations = append(response.Authorizations, s.URL+"/authz/"+strconv.Itoa(index))
+		switch s.authorizations[index].status {
+		case acme.StatusInvalid:
+			response.Status = acme.StatusInvalid
+		case acme.StatusPending:
+			if response.Status != acme.StatusInvalid {
+				response.Status = acme.StatusPending
+			}
+		}
+	}
+	if order.certificate >= 0 {
+		response.Status = acme.StatusValid
+		response.Certificate = s.URL + "/certificate/" + strconv.Itoa(order.certificate)
+	}
+	return response
+}
+
+// lookup returns the index of the object of account at the end of the request path.
+func (s *testACMEServer) lookup(w http.ResponseWriter, r *http.Request, prefix string, count int, accountOf func(index int) int) (int, []byte, bool) {
+	payload, account, ok := s.verifyAccount(w, r)
+	if !ok {
+		return 0, nil, false
+	}
+	index, err := strconv.Atoi(strings.SplitN(strings.TrimPrefix(r.URL.Path, prefix), "/", 2)[0])
+	s.access.Lock()
+	defer s.access.Unlock()
+	if err != nil || index < 0 || index >= count || accountOf(index) != account {
+		writeProblem(w, http.StatusNotFound, "malformed", "not found")
+		return 0, nil, false
+	}
+	return index, payload, true
+}
+
+func (s *testACMEServer) getOrder(w http.ResponseWriter, r *http.Request) {
+	id, _, ok := s.lookup(w, r, "/order/", s.count(&s.orders), s.orderAccount)
+	if !ok {
+		return
+	}
+	s.access.Lock()
+	response := s.orderObject(id)
+	s.access.Unlock()
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *testACMEServer) getAuthorization(w http.ResponseWriter, r *http.Request) {
+	id, _, ok := s.lookup(w, r, "/authz/", s.count(&s.authorizations), s.authorizationAccount)
+	if !ok {
+		return
+	}
+	s.access.Lock()
+	response := s.authorizationObject(id)
+	s.access.Unlock()
+	writeJSON(w, http.StatusOK, response)
+}
+
+// authorizationObject is called with access held.
+func (s *testACMEServer) authorizationObject(id int) acme.Authorization {
+	authorization := s.authorizations[id]
+	response := acme.Authorization{
+		Status:     authorization.status,
+		Expires:    s.clock.Now().Add(24 * time.Hour),
+		Identifier: authorization.identifier,
+	}
+	for _, challengeType := range testChallengeTypes {
+		response.Challenges = append(response.Challenges, s.challengeObject(id, challengeType))
+	}
+	return response
+}
+
+// challengeObject is called with access held.
+func (s *testACMEServer) challengeObject(id int, challengeType string) acme.Challenge {
+	authorization := s.authorizations[id]
+	challenge := acme.Challenge{
+		Type:   challengeType,
+		URL:    s.URL + "/challenge/" + strconv.Itoa(id) + "/" + challengeType,
+		Status: acme.StatusPending,
+		Token:  authorization.token,
+	}
+	if authorization.validated == challengeType {
+		challenge.Status = authorization.status
+		challenge.Error = authorization.err
+	}
+	return challenge
+}
+
+func (s *testACMEServer) validate(w http.ResponseWriter, r *http.Request) {
+	id, _, ok := s.lookup(w, r, "/challenge/", s.count(&s.authorizations), s.authorizationAccount)
+	if !ok {
+		return
+	}
+	challengeType := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	s.access.Lock()
+	authorization := s.authorizations[id]
+	thumbprint, _ := s.accounts[authorization.account].Thumbprint(crypto.SHA256)
+	keyAuth := authorization.token + "." + base64.RawURLEncoding.EncodeToString(thumbprint)
+	domain := authorization.identifier.Value
+	pending := authorization.status == acme.StatusPending
+	s.access.Unlock()
+
+	if pending {
+		var err error
+		switch challengeType {
+		case "http-01":
+			err = s.validateHTTP01(domain, authorization.token, keyAuth)
+		case "tls-alpn-01":
+			err = checkTLSALPN01(s.tlsAddress, domain, keyAuth)
+		default:
+			err = E.New("unsupported challenge ", challengeType)
+		}
+		s.access.Lock()
+		authorization.validated = challengeType
+		if err != nil {
+			authorization.status = acme.StatusInvalid
+			authorization.err = &acme.ProblemDetails{
+				Type:   "urn:ietf:params:acme:error:unauthorized",
+				Detail: err.Error(),
+			}
+		} else {
+			authorization.status = acme.StatusValid
+		}
+		s.access.Unlock()
+	}
+	s.access.Lock()
+	response := s.challengeObject(id, challengeType)
+	s.access.Unlock()
+	w.Header().Add("Link", "<"+s.URL+"/authz/"+strconv.Itoa(id)+`>;rel="up"`)
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *testACMEServer) validateHTTP01(domain string, token string, keyAuth string) error {
+	if s.httpAddress == "" {
+		return E.New("http-01 disabled")
+	}
+	request, err := http.NewRequest(http.MethodGet, "http://"+s.httpAddress+http01.ChallengePath(token), nil)
+	if err != nil {
+		return err
+	}
+	request.Host = domain
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	content, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK || strings.TrimSpace(string(content)) != keyAuth {
+		return E.New("bad key authorization for ", domain, ": ", response.Status)
+	}
+	return nil
+}
+
+func (s *testACMEServer) finalize(w http.ResponseWriter, r *http.Request) {
+	id, payload, ok := s.lookup(w, r, "/finalize/", s.count(&s.orders), s.orderAccount)
+	if !ok {
+		return
+	}
+	var request acme.CSRMessage
+	err := json.Unmarshal(payload, &request)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	content, err := base64.RawURLEncoding.DecodeString(request.Csr)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "badCSR", err.Error())
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(content)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "badCSR", err.Error())
+		return
+	}
+	s.access.Lock()
+	order := s.orders[id]
+	status := s.orderObject(id).Status
+	var names []string
+	for _, identifier := range order.identifiers {
+		names = append(names, strings.ToLower(identifier.Value))
+	}
+	s.access.Unlock()
+	if status != acme.StatusReady {
+		writeProblem(w, http.StatusForbidden, "orderNotReady", "order is "+status)
+		return
+	}
+	requested := append([]string(nil), csr.DNSNames...)
+	sort.Strings(names)
+	sort.Strings(requested)
+	if strings.Join(names, ",") != strings.Join(requested, ",") {
+		writeProblem(w, http.StatusBadRequest, "badCSR", "names do not match the order")
+		return
+	}
+
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	now := s.clock.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    now,
+		NotAfter:     now.Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		OCSPServer:   s.ocspServer,
+	}
+	leaf, err := x509.CreateCertificate(rand.Reader, template, s.issuer, csr.PublicKey, s.key)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, "serverInternal", err.Error())
+		return
+	}
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf})
+	chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.issuer.Raw})...)
+	s.access.Lock()
+	order.certificate = len(s.certificates)
+	s.certificates = append(s.certificates, chain)
+	response := s.orderObject(id)
+	s.access.Unlock()
+	w.Header().Set("Location", s.URL+"/order/"+strconv.Itoa(id))
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *testACMEServer) getCertificate(w http.ResponseWriter, r *http.Request) {
+	id, _, ok := s.lookup(w, r, "/certificate/", s.count(&s.certificates), s.certificateAccount)
+	if !ok {
+		return
+	}
+	s.access.Lock()
+	chain := s.certificates[id]
+	s.access.Unlock()
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.Write(chain)
+}
+
+// issuedCount returns the number of certificates issued.
+func (s *testACMEServer) issuedCount() int {
+	s.access.Lock()
+	defer s.access.Unlock()
+	return len(s.certificates)
+}
+
+func (s *testACMEServer) count(objects any) int {
+	s.access.Lock()
+	defer s.access.Unlock()
+	switch objects := objects.(type) {
+	case *[]*testOrder:
+		return len(*objects)
+	case *[]*testAuthorization:
+		return len(*objects)
+	case *[][]byte:
+		return len(*objects)
+	}
+	return 0
+}
+
+func (s *testACMEServer) orderAccount(index int) int {
+	return s.orders[index].account
+}
+
+func (s *testACMEServer) authorizationAccount(index int) int {
+	return s.authorizations[index].account
+}
+
+func (s *testACMEServer) certificateAccount(index int) int {
+	for _, order := range s.orders {
+		if order.certificate == index {
+			return order.account
+		}
+	}
+	return -1
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeProblem(w http.ResponseWriter, status int, problemType string, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(acme.ProblemDetails{
+		Type:       "urn:ietf:params:acme:error:" + problemType,
+		Detail:     detail,
+		HTTPStatus: status,
+	})
+}
+
+func TestObtainHTTP01(t *testing.T) {
+	address := freeAddress(t)
+	manager, _, server := newTestACMEManager(t, &Settings{
+		HTTPChallenge: &ChallengeSettings{Listen: address},
+	})
+	server.httpAddress = address
+	keyPair, err := manager.GetKeyPair("Example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyTestCertificate(t, server, keyPair.Leaf, "example.com")
+	for _, extension := range certificateExtensions[:3] {
+		if !manager.storage.Exists("example.com" + extension) {
+			t.Fatal("missing stored ", extension)
+		}
+	}
+
+	cached, err := manager.GetKeyPair("example.com")
+	if err != nil || !cached.Leaf.Equal(keyPair.Leaf) || server.issuedCount() != 1 {
+		t.Fatal("expected the obtained certificate to be reused")
+	}
+}
+
+func TestObtainTLSALPN01(t *testing.T) {
+	address := freeAddress(t)
+	manager, _, server := newTestACMEManager(t, &Settings{
+		TLSALPNChallenge: &ChallengeSettings{Listen: address},
+	})
+	server.tlsAddress = address
+	keyPair, err := manager.GetKeyPairForDomains([]string{"example.com", "www.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyTestCertificate(t, server, keyPair.Leaf, "example.com", "www.example.com")
+}
+
+func TestObtainChallengeFailure(t *testing.T) {
+	manager, _, server := newTestACMEManager(t, &Settings{
+		HTTPChallenge: &ChallengeSettings{Listen: freeAddress(t)},
+	})
+	// the server validates at an address nothing listens on
+	server.httpAddress = freeAddress(t)
+	_, err := manager.GetKeyPair("example.com")
+	if err == nil {
+		t.Fatal("expected failed validation to fail")
+	}
+	if server.issuedCount() != 0 || manager.storage.Exists("example.com.crt") {
+		t.Fatal("certificate issued without validation")
+	}
+}
+
+func verifyTestCertificate(t *testing.T, server *testACMEServer, leaf *x509.Certificate, names ...string) {
+	t.Helper()
+	roots := x509.NewCertPool()
+	roots.AddCert(server.issuer)
+	for _, name := range names {
+		_, err := leaf.Verify(x509.VerifyOptions{
+			DNSName:     name,
+			Roots:       roots,
+			CurrentTime: server.clock.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
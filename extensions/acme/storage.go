@@ -0,0 +1,154 @@
+package acme
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+// Storage keeps certificates, private keys and account data by flat names like
+// example.com.crt. Load returns an error wrapping os.ErrNotExist for missing names.
+type Storage interface {
+	Load(name string) ([]byte, error)
+	Store(name string, content []byte) error
+	Exists(name string) bool
+	Delete(name string) error
+	List() ([]string, error)
+	// Lock blocks until name is locked, also against other processes sharing the storage.
+	Lock(name string) (unlock func(), err error)
+}
+
+type StorageSettings struct {
+	// Type is one of file (default), encrypted and memory.
+	Type string `json:"type"`
+	// Key encrypts the encrypted storage, a base64 encoded random secret of at least
+	// 32 bytes, read from KeyFile if empty.
+	Key     string `json:"key"`
+	KeyFile string `json:"key_file"`
+	// MigratePlaintext lets the encrypted storage read files written before encryption
+	// was enabled, they are encrypted when stored again.
+	MigratePlaintext bool `json:"migrate_plaintext"`
+}
+
+func NewStorage(path string, settings *StorageSettings) (Storage, error) {
+	if settings == nil {
+		return NewFileStorage(path), nil
+	}
+	switch settings.Type {
+	case "", "file":
+		return NewFileStorage(path), nil
+	case "memory":
+		return NewMemoryStorage(), nil
+	case "encrypted":
+		key := settings.Key
+		if key == "" && settings.KeyFile != "" {
+			content, err := ioutil.ReadFile(settings.KeyFile)
+			if err != nil {
+				return nil, E.Cause(err, "acme: read storage key")
+			}
+			key = strings.TrimSpace(string(content))
+		}
+		if key == "" {
+			return nil, E.New("acme: missing storage key")
+		}
+		secret, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, E.Cause(err, "acme: decode storage key")
+		}
+		if settings.MigratePlaintext {
+			return NewMigratingEncryptedStorage(NewFileStorage(path), secret)
+		}
+		return NewEncryptedStorage(NewFileStorage(path), secret)
+	default:
+		return nil, E.New("acme: unknown storage type ", settings.Type)
+	}
+}
+
+func loadJSON(storage Storage, name string, value any) error {
+	content, err := storage.Load(name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, value)
+}
+
+func storeJSON(storage Storage, name string, value any) error {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.Store(name, content)
+}
+
+type memoryStorage struct {
+	access  sync.Mutex
+	content map[string][]byte
+	locks   map[string]*sync.Mutex
+}
+
+func NewMemoryStorage() Storage {
+	return &memoryStorage{
+		content: make(map[string][]byte),
+		locks:   make(map[string]*sync.Mutex),
+	}
+}
+
+func (s *memoryStorage) Load(name string) ([]byte, error) {
+	s.access.Lock()
+	defer s.access.Unlock()
+	content, loaded := s.content[name]
+	if !loaded {
+		return nil, E.Cause(os.ErrNotExist, name)
+	}
+	return append([]byte(nil), content...), nil
+}
+
+func (s *memoryStorage) Store(name string, content []byte) error {
+	s.access.Lock()
+	defer s.access.Unlock()
+	s.content[name] = append([]byte(nil), content...)
+	return nil
+}
+
+func (s *memoryStorage) Exists(name string) bool {
+	s.access.Lock()
+	defer s.access.Unlock()
+	_, loaded := s.content[name]
+	return loaded
+}
+
+func (s *memoryStorage) Delete(name string) error {
+	s.access.Lock()
+	defer s.access.Unlock()
+	delete(s.content, name)
+	return nil
+}
+
+func (s *memoryStorage) List() ([]string, error) {
+	s.access.Lock()
+	defer s.access.Unlock()
+	names := make([]string, 0, len(s.content))
+	for name := range s.content {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *memoryStorage) Lock(name string) (func(), error) {
+	s.access.Lock()
+	lock := s.locks[name]
+	if lock == nil {
+		lock = new(sync.Mutex)
+		s.locks[name] = lock
+	}
+	s.access.Unlock()
+	lock.Lock()
+	return lock.Unlock, nil
+}
@@ -0,0 +1,82 @@
+package acme
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+var encryptedHeader = []byte("sing-acme-encrypted-v1\n")
+
+type encryptedStorage struct {
+	Storage
+	aead             cipher.AEAD
+	migratePlaintext bool
+}
+
+// NewEncryptedStorage encrypts content of storage at rest with AES-256-GCM, using a key
+// derived from secret. Content without the encryption header is rejected.
+func NewEncryptedStorage(storage Storage, secret []byte) (Storage, error) {
+	return newEncryptedStorage(storage, secret, false)
+}
+
+// NewMigratingEncryptedStorage is NewEncryptedStorage that also reads files written
+// before encryption was enabled as is, they are encrypted when stored again.
+func NewMigratingEncryptedStorage(storage Storage, secret []byte) (Storage, error) {
+	return newEncryptedStorage(storage, secret, true)
+}
+
+func newEncryptedStorage(storage Storage, secret []byte, migratePlaintext bool) (Storage, error) {
+	if len(secret) < 32 {
+		return nil, E.New("acme: storage key must be at least 32 bytes")
+	}
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedStorage{storage, aead, migratePlaintext}, nil
+}
+
+func (s *encryptedStorage) Load(name string) ([]byte, error) {
+	content, err := s.Storage.Load(name)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(content, encryptedHeader) {
+		if s.migratePlaintext {
+			return content, nil
+		}
+		return nil, E.New("acme: ", name, " is not encrypted, enable migrate_plaintext to read it")
+	}
+	content = content[len(encryptedHeader):]
+	nonceSize := s.aead.NonceSize()
+	if len(content) < nonceSize {
+		return nil, E.New("acme: bad encrypted content in ", name)
+	}
+	// the name is authenticated so that files can not be swapped
+	content, err = s.aead.Open(nil, content[:nonceSize], content[nonceSize:], []byte(name))
+	if err != nil {
+		return nil, E.Cause(err, "acme: decrypt ", name)
+	}
+	return content, nil
+}
+
+func (s *encryptedStorage) Store(name string, content []byte) error {
+	nonce := make([]byte, s.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+	sealed := append(append([]byte(nil), encryptedHeader...), nonce...)
+	sealed = s.aead.Seal(sealed, nonce, content, []byte(name))
+	return s.Storage.Store(name, sealed)
+}
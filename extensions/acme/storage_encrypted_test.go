@@ -0,0 +1,72 @@
+package acme
+
+import (
+	"bytes"
+	"testing"
+)
+
+var testStorageSecret = bytes.Repeat([]byte{1}, 32)
+
+func TestEncryptedStorage(t *testing.T) {
+	backend := NewMemoryStorage()
+	storage, err := NewEncryptedStorage(backend, testStorageSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.Store("example.com.key", []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, _ := backend.Load("example.com.key")
+	if !bytes.HasPrefix(sealed, encryptedHeader) || bytes.Contains(sealed, []byte("key\n")) {
+		t.Fatal("content stored in plaintext")
+	}
+	content, err := storage.Load("example.com.key")
+	if err != nil || string(content) != "key" {
+		t.Fatal("bad content ", string(content), err)
+	}
+	backend.Store("example.org.key", sealed)
+	_, err = storage.Load("example.org.key")
+	if err == nil {
+		t.Fatal("expected swapped file to be rejected")
+	}
+}
+
+func TestEncryptedStorageRejectsPlaintext(t *testing.T) {
+	backend := NewMemoryStorage()
+	backend.Store("example.com.key", []byte("plaintext"))
+	storage, err := NewEncryptedStorage(backend, testStorageSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = storage.Load("example.com.key")
+	if err == nil {
+		t.Fatal("expected plaintext to be rejected")
+	}
+}
+
+func TestEncryptedStorageMigratePlaintext(t *testing.T) {
+	backend := NewMemoryStorage()
+	backend.Store("example.com.key", []byte("plaintext"))
+	storage, err := NewMigratingEncryptedStorage(backend, testStorageSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := storage.Load("example.com.key")
+	if err != nil || string(content) != "plaintext" {
+		t.Fatal("bad content ", string(content), err)
+	}
+	err = storage.Store("example.com.key", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, _ := backend.Load("example.com.key")
+	if !bytes.HasPrefix(sealed, encryptedHeader) {
+		t.Fatal("content not encrypted on store")
+	}
+	strict, _ := NewEncryptedStorage(backend, testStorageSecret)
+	content, err = strict.Load("example.com.key")
+	if err != nil || string(content) != "plaintext" {
+		t.Fatal("bad content ", string(content), err)
+	}
+}
@@ -0,0 +1,131 @@
+package acme
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/rw"
+)
+
+const lockSuffix = ".lock"
+
+type fileStorage struct {
+	path string
+}
+
+// NewFileStorage stores every name as a file in path, the layout used before storage
+// backends were introduced.
+func NewFileStorage(path string) Storage {
+	return &fileStorage{path}
+}
+
+func (s *fileStorage) file(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", E.New("acme: bad storage name ", name)
+	}
+	return filepath.Join(s.path, name), nil
+}
+
+func (s *fileStorage) Load(name string) ([]byte, error) {
+	path, err := s.file(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+func (s *fileStorage) Store(name string, content []byte) error {
+	path, err := s.file(name)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(s.path, 0o700)
+	if err != nil {
+		return err
+	}
+	// write and rename, so that readers never see a partial file
+	temporary, err := ioutil.TempFile(s.path, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	_, err = temporary.Write(content)
+	if err == nil {
+		err = temporary.Sync()
+	}
+	closeErr := temporary.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temporary.Name(), path)
+	}
+	if err != nil {
+		os.Remove(temporary.Name())
+	}
+	return err
+}
+
+func (s *fileStorage) Exists(name string) bool {
+	path, err := s.file(name)
+	return err == nil && rw.FileExists(path)
+}
+
+func (s *fileStorage) Delete(name string) error {
+	path, err := s.file(name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *fileStorage) List() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, lockSuffix) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *fileStorage) Lock(name string) (func(), error) {
+	path, err := s.file(name + lockSuffix)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(s.path, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	err = lockFile(file)
+	if err != nil {
+		file.Close()
+		return nil, E.Cause(err, "acme: lock ", name)
+	}
+	return func() {
+		unlockFile(file)
+		file.Close()
+	}, nil
+}
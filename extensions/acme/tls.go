@@ -0,0 +1,208 @@
+package acme
+
+import (
+	"crypto/tls"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge/tlsalpn01"
+	"github.com/sagernet/sing/common"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+// on-demand certificates are limited so that handshakes can not be used to flood the
+// ACME server: failed names are not retried within onDemandRetryInterval, at most
+// onDemandMaxPending names are obtained at once and at most onDemandMaxObtains are
+// started within onDemandRetryInterval
+const (
+	onDemandRetryInterval = 10 * time.Minute
+	onDemandMaxPending    = 4
+	onDemandMaxObtains    = 10
+)
+
+// TLSConfig returns a tls.Config which serves managed certificates by SNI. Every name in
+// domains gets its own certificate, obtained before TLSConfig returns. Names matching one
+// of onDemand, like example.com or *.example.com for any subdomain, are obtained on their
+// first handshake. Renewed certificates are picked up without restarting the server.
+//
+// nextProtos are the application protocols of the server, h2 and http/1.1 by default.
+// "acme-tls/1" is appended if the TLS-ALPN-01 challenge is enabled, callers changing
+// NextProtos later must keep it.
+func (c *CertificateManager) TLSConfig(domains []string, onDemand []string, nextProtos ...string) (*tls.Config, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	domains = sortDomains(domains)
+	for _, domain := range domains {
+		err := c.manage([]string{domain})
+		if err != nil {
+			return nil, err
+		}
+	}
+	handler := &tlsHandler{
+		manager:  c,
+		onDemand: sortDomains(onDemand),
+		pending:  make(map[string]chan struct{}),
+		failed:   make(map[string]time.Time),
+	}
+	if len(domains) > 0 {
+		handler.fallback = domains[0]
+	}
+	if len(nextProtos) == 0 {
+		nextProtos = []string{"h2", "http/1.1"}
+	}
+	config := &tls.Config{
+		GetCertificate: handler.GetCertificate,
+		NextProtos:     append([]string(nil), nextProtos...),
+	}
+	if c.tlsALPNChallenge != nil && !common.Contains(config.NextProtos, tlsalpn01.ACMETLS1Protocol) {
+		config.NextProtos = append(config.NextProtos, tlsalpn01.ACMETLS1Protocol)
+	}
+	return config, nil
+}
+
+// manage obtains the certificate for domains and keeps it renewed.
+func (c *CertificateManager) manage(domains []string) error {
+	_, err := c.GetKeyPairsForDomains(domains)
+	if err != nil {
+		return err
+	}
+	domains = sortDomains(domains)
+	key := StorageKey(domains)
+	c.access.Lock()
+	defer c.access.Unlock()
+	c.managed[key] = true
+	c.domains[key] = domains
+	if c.renewClose == nil {
+		c.start()
+	}
+	return nil
+}
+
+// lookupCertificates returns the latest certificates covering name, exactly or by wildcard.
+func (c *CertificateManager) lookupCertificates(name string) []*tls.Certificate {
+	c.access.Lock()
+	defer c.access.Unlock()
+	key, loaded := c.names[name]
+	if !loaded {
+		if index := strings.IndexByte(name, '.'); index > 0 {
+			key, loaded = c.names["*"+name[index:]]
+		}
+	}
+	if !loaded {
+		return nil
+	}
+	return c.certificates[key]
+}
+
+type tlsHandler struct {
+	manager  *CertificateManager
+	onDemand []string
+	fallback string
+
+	access  sync.Mutex
+	pending map[string]chan struct{}
+	failed  map[string]time.Time
+	started []time.Time
+}
+
+func (h *tlsHandler) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certificate, err := h.manager.GetChallengeCertificate(hello)
+	if certificate != nil || err != nil {
+		return certificate, err
+	}
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if name == "" {
+		name = h.fallback
+	}
+	certificates := h.manager.lookupCertificates(name)
+	if certificates == nil && h.allowed(name) {
+		certificates, err = h.obtain(hello, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if certificates == nil {
+		return nil, E.New("acme: no certificate for ", name)
+	}
+	return ChooseCertificate(hello, certificates), nil
+}
+
+func (h *tlsHandler) allowed(name string) bool {
+	if name == "" || strings.Contains(name, "*") {
+		return false
+	}
+	return common.Any(h.onDemand, func(pattern string) bool {
+		if isWildcard(pattern) {
+			// a wildcard covers exactly one label, like in certificates
+			label := strings.TrimSuffix(name, pattern[1:])
+			return label != name && label != "" && !strings.Contains(label, ".")
+		}
+		return name == pattern
+	})
+}
+
+func (h *tlsHandler) obtain(hello *tls.ClientHelloInfo, name string) ([]*tls.Certificate, error) {
+	done, err := h.begin(name)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-done:
+	case <-hello.Context().Done():
+		return nil, hello.Context().Err()
+	}
+	certificates := h.manager.lookupCertificates(name)
+	if certificates == nil {
+		return nil, E.New("acme: obtain certificate for ", name, " failed")
+	}
+	return certificates, nil
+}
+
+// begin starts obtaining the certificate for name unless it is pending already, and
+// returns the channel closed once done.
+func (h *tlsHandler) begin(name string) (chan struct{}, error) {
+	h.access.Lock()
+	defer h.access.Unlock()
+	now := h.manager.clock.Now()
+	h.expire(now)
+	if _, loaded := h.failed[name]; loaded {
+		return nil, E.New("acme: obtain certificate for ", name, " failed recently")
+	}
+	done, loaded := h.pending[name]
+	if loaded {
+		return done, nil
+	}
+	if len(h.pending) >= onDemandMaxPending || len(h.started) >= onDemandMaxObtains {
+		return nil, E.New("acme: too many on-demand certificates, rejected ", name)
+	}
+	done = make(chan struct{})
+	h.pending[name] = done
+	h.started = append(h.started, now)
+	go h.runObtain(name, done)
+	return done, nil
+}
+
+func (h *tlsHandler) runObtain(name string, done chan struct{}) {
+	err := h.manager.manage([]string{name})
+	h.access.Lock()
+	delete(h.pending, name)
+	if err != nil {
+		h.failed[name] = h.manager.clock.Now()
+	}
+	h.access.Unlock()
+	close(done)
+}
+
+// expire forgets failures and started obtains older than onDemandRetryInterval.
+func (h *tlsHandler) expire(now time.Time) {
+	for name, failedAt := range h.failed {
+		if now.Sub(failedAt) >= onDemandRetryInterval {
+			delete(h.failed, name)
+		}
+	}
+	for len(h.started) > 0 && now.Sub(h.started[0]) >= onDemandRetryInterval {
+		h.started = h.started[1:]
+	}
+}
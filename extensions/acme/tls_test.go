@@ -0,0 +1,174 @@
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge/tlsalpn01"
+	"github.com/sagernet/sing/common"
+)
+
+func TestOnDemandAllowed(t *testing.T) {
+	handler := &tlsHandler{onDemand: sortDomains([]string{"example.com", "*.example.org"})}
+	for name, allowed := range map[string]bool{
+		"example.com":         true,
+		"www.example.com":     false,
+		"www.example.org":     true,
+		"a.b.example.org":     false,
+		"example.org":         false,
+		".example.org":        false,
+		"wwwexample.org":      false,
+		"*.example.org":       false,
+		"www.example.org.com": false,
+		"":                    false,
+	} {
+		if handler.allowed(name) != allowed {
+			t.Error(name, ": expected allowed ", allowed)
+		}
+	}
+}
+
+func newTestHandler(t *testing.T) (*tlsHandler, *fakeClock) {
+	manager, clock := newTestManager(t, nil)
+	return &tlsHandler{
+		manager:  manager,
+		onDemand: []string{"*.example.com"},
+		pending:  make(map[string]chan struct{}),
+		failed:   make(map[string]time.Time),
+	}, clock
+}
+
+func waitDone(t *testing.T, done chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("timeout waiting for obtain")
+	}
+}
+
+func TestOnDemandFailedExpire(t *testing.T) {
+	handler, clock := newTestHandler(t)
+	done, err := handler.begin("a.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitDone(t, done)
+	_, err = handler.begin("a.example.com")
+	if err == nil {
+		t.Fatal("expected failed name to be rejected")
+	}
+	clock.Advance(onDemandRetryInterval)
+	handler.access.Lock()
+	handler.expire(clock.Now())
+	failed := len(handler.failed)
+	handler.access.Unlock()
+	if failed != 0 {
+		t.Fatal("expected failed entry to expire")
+	}
+	done, err = handler.begin("a.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitDone(t, done)
+}
+
+func TestOnDemandLimits(t *testing.T) {
+	handler, clock := newTestHandler(t)
+	handler.access.Lock()
+	for i := 0; i < onDemandMaxPending; i++ {
+		handler.pending[string(rune('a'+i))+".example.com"] = make(chan struct{})
+	}
+	handler.access.Unlock()
+	_, err := handler.begin("z.example.com")
+	if err == nil {
+		t.Fatal("expected concurrency limit")
+	}
+	done, err := handler.begin("a.example.com")
+	if err != nil || done == nil {
+		t.Fatal("expected pending name to be joined: ", err)
+	}
+
+	handler.access.Lock()
+	handler.pending = make(map[string]chan struct{})
+	for i := 0; i < onDemandMaxObtains; i++ {
+		handler.started = append(handler.started, clock.Now())
+	}
+	handler.access.Unlock()
+	_, err = handler.begin("z.example.com")
+	if err == nil {
+		t.Fatal("expected rate limit")
+	}
+	clock.Advance(onDemandRetryInterval)
+	done, err = handler.begin("z.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitDone(t, done)
+}
+
+func TestTLSConfigApplicationProtocols(t *testing.T) {
+	address := freeAddress(t)
+	manager, _, server := newTestACMEManager(t, &Settings{
+		TLSALPNChallenge: &ChallengeSettings{Listen: address},
+	})
+	server.tlsAddress = address
+	config, err := manager.TLSConfig([]string{"example.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !common.Contains(config.NextProtos, tlsalpn01.ACMETLS1Protocol) {
+		t.Fatal("missing acme-tls/1 in ", config.NextProtos)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})}
+	go httpServer.Serve(listener)
+	defer httpServer.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.issuer)
+	for _, protocols := range [][]string{{"h2", "http/1.1"}, {"http/1.1"}} {
+		transport := &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs:    roots,
+				NextProtos: protocols,
+				Time:       server.clock.Now,
+			},
+			ForceAttemptHTTP2: protocols[0] == "h2",
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, listener.Addr().String())
+			},
+		}
+		response, err := (&http.Client{Transport: transport}).Get("https://example.com/")
+		if err != nil {
+			t.Fatal(protocols, ": ", err)
+		}
+		response.Body.Close()
+		expected := "HTTP/1.1"
+		if protocols[0] == "h2" {
+			expected = "HTTP/2.0"
+		}
+		if response.Proto != expected {
+			t.Fatal(protocols, ": expected ", expected, ", got ", response.Proto)
+		}
+		transport.CloseIdleConnections()
+	}
+
+	config, err = manager.TLSConfig(nil, nil, "http/1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.NextProtos) != 2 || config.NextProtos[0] != "http/1.1" || config.NextProtos[1] != tlsalpn01.ACMETLS1Protocol {
+		t.Fatal("unexpected protocols ", config.NextProtos)
+	}
+}
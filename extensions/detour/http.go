@@ -0,0 +1,73 @@
+package detour
+
+import (
+	std_bufio "bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sagernet/sing/common/buf"
+	"github.com/sagernet/sing/common/bufio"
+	E "github.com/sagernet/sing/common/exceptions"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+type httpOutbound struct {
+	dialer   N.ContextDialer
+	server   M.Socksaddr
+	username string
+	password string
+}
+
+func (o *httpOutbound) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	if !strings.HasPrefix(network, "tcp") {
+		return nil, E.New("http: udp unsupported")
+	}
+	conn, err := o.dialer.DialContext(ctx, "tcp", o.server)
+	if err != nil {
+		return nil, err
+	}
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Host: destination.String()},
+		Host:   destination.String(),
+		Header: make(http.Header),
+	}
+	if o.username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(o.username + ":" + o.password))
+		request.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	err = request.Write(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := std_bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, E.New("http: unexpected response status ", response.Status)
+	}
+	if buffered := reader.Buffered(); buffered > 0 {
+		cached := buf.NewSize(buffered)
+		_, err = cached.ReadFullFrom(reader, buffered)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return bufio.NewCachedConn(conn, cached), nil
+	}
+	return conn, nil
+}
+
+func (o *httpOutbound) ListenPacket(ctx context.Context, destination M.Socksaddr) (net.PacketConn, error) {
+	return nil, E.New("http: udp unsupported")
+}
@@ -0,0 +1,78 @@
+package detour
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+// newConnectProxy returns an HTTP proxy which greets on tunnels to destination and
+// echoes everything after.
+func newConnectProxy(t *testing.T, destination string) *httptest.Server {
+	authorization := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect || r.Host != destination {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Proxy-Authorization") != authorization {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		conn, bufrw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// the greeting is sent with the response, so the client reads it buffered
+		bufrw.WriteString("HTTP/1.1 200 Connection established\r\n\r\nhello")
+		bufrw.Flush()
+		io.Copy(conn, bufrw)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHTTPConnect(t *testing.T) {
+	server := newConnectProxy(t, "example.com:443")
+	outbound := &httpOutbound{N.SystemDialer, M.ParseSocksaddr(server.Listener.Addr().String()), "user", "pass"}
+	conn, err := outbound.DialContext(context.Background(), "tcp", M.ParseSocksaddrHostPort("example.com", 443))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	greeting := make([]byte, 5)
+	_, err = io.ReadFull(conn, greeting)
+	if err != nil || string(greeting) != "hello" {
+		t.Fatal("expected buffered greeting, got ", string(greeting), err)
+	}
+	_, err = conn.Write([]byte("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo := make([]byte, 4)
+	_, err = io.ReadFull(conn, echo)
+	if err != nil || string(echo) != "ping" {
+		t.Fatal("expected echo, got ", string(echo), err)
+	}
+}
+
+func TestHTTPConnectRejected(t *testing.T) {
+	server := newConnectProxy(t, "example.com:443")
+	destination := M.ParseSocksaddrHostPort("example.com", 443)
+	outbound := &httpOutbound{N.SystemDialer, M.ParseSocksaddr(server.Listener.Addr().String()), "user", "wrong"}
+	_, err := outbound.DialContext(context.Background(), "tcp", destination)
+	if err == nil {
+		t.Fatal("expected proxy authentication failure")
+	}
+	_, err = outbound.DialContext(context.Background(), "udp", destination)
+	if err == nil {
+		t.Fatal("expected udp to be rejected")
+	}
+}
@@ -0,0 +1,74 @@
+package detour
+
+import (
+	"context"
+	"net"
+
+	"github.com/sagernet/sing-tools/extensions/dialer"
+	"github.com/sagernet/sing/common"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+var _ N.ContextDialer = (*Router)(nil)
+
+// Router sends connections matched by the detour rules (or all of them, if there
+// are no rules) through the detour, and everything else directly.
+type Router struct {
+	direct *dialer.Dialer
+	detour Outbound
+	rules  []*Rule
+}
+
+func NewRouter(direct *dialer.Dialer, options *Options) (*Router, error) {
+	r := &Router{
+		direct: direct,
+	}
+	if options == nil {
+		return r, nil
+	}
+	detour, err := New(direct, *options)
+	if err != nil {
+		return nil, err
+	}
+	r.detour = detour
+	for _, ruleOptions := range options.Rules {
+		rule, err := NewRule(ruleOptions)
+		if err != nil {
+			return nil, err
+		}
+		r.rules = append(r.rules, rule)
+	}
+	return r, nil
+}
+
+func (r *Router) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	if r.match(destination) {
+		return r.detour.DialContext(ctx, network, destination)
+	}
+	return r.direct.DialContext(ctx, network, destination)
+}
+
+// ListenPacket routes by the first destination of the session, an invalid destination
+// only matches when the detour has no rules.
+func (r *Router) ListenPacket(ctx context.Context, destination M.Socksaddr) (net.PacketConn, error) {
+	if r.match(destination) {
+		return r.detour.ListenPacket(ctx, destination)
+	}
+	return r.direct.ListenPacket(ctx)
+}
+
+func (r *Router) match(destination M.Socksaddr) bool {
+	if r.detour == nil {
+		return false
+	}
+	if len(r.rules) == 0 {
+		return true
+	}
+	if !destination.IsValid() {
+		return false
+	}
+	return common.Any(r.rules, func(it *Rule) bool {
+		return it.Match(destination)
+	})
+}
@@ -0,0 +1,81 @@
+package detour
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/sagernet/sing/common"
+	E "github.com/sagernet/sing/common/exceptions"
+	M "github.com/sagernet/sing/common/metadata"
+)
+
+type RuleOptions struct {
+	Domain       []string `json:"domain"`
+	DomainSuffix []string `json:"domain_suffix"`
+	IPCIDR       []string `json:"ip_cidr"`
+	Port         []uint16 `json:"port"`
+}
+
+// Rule matches when every non-empty condition matches the destination.
+type Rule struct {
+	domain       []string
+	domainSuffix []string
+	ipCIDR       []netip.Prefix
+	port         []uint16
+}
+
+func NewRule(options RuleOptions) (*Rule, error) {
+	rule := &Rule{
+		domain: common.Map(options.Domain, func(it string) string {
+			return strings.ToLower(strings.TrimSuffix(it, "."))
+		}),
+		domainSuffix: common.Map(options.DomainSuffix, func(it string) string {
+			return strings.ToLower(strings.Trim(it, "."))
+		}),
+		port: options.Port,
+	}
+	for _, cidr := range options.IPCIDR {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(cidr)
+			if addrErr != nil {
+				return nil, E.Cause(err, "parse ip_cidr ", cidr)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		rule.ipCIDR = append(rule.ipCIDR, prefix)
+	}
+	if len(rule.domain) == 0 && len(rule.domainSuffix) == 0 && len(rule.ipCIDR) == 0 && len(rule.port) == 0 {
+		return nil, E.New("empty detour rule")
+	}
+	return rule, nil
+}
+
+func (r *Rule) Match(destination M.Socksaddr) bool {
+	if len(r.domain) > 0 || len(r.domainSuffix) > 0 {
+		if !destination.IsFqdn() {
+			return false
+		}
+		domain := strings.ToLower(strings.TrimSuffix(destination.Fqdn, "."))
+		if !common.Contains(r.domain, domain) && !common.Any(r.domainSuffix, func(suffix string) bool {
+			return domain == suffix || strings.HasSuffix(domain, "."+suffix)
+		}) {
+			return false
+		}
+	}
+	if len(r.ipCIDR) > 0 {
+		if !destination.IsIP() {
+			return false
+		}
+		addr := destination.Addr.Unmap()
+		if !common.Any(r.ipCIDR, func(prefix netip.Prefix) bool {
+			return prefix.Contains(addr)
+		}) {
+			return false
+		}
+	}
+	if len(r.port) > 0 && !common.Contains(r.port, destination.Port) {
+		return false
+	}
+	return true
+}
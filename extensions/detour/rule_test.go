@@ -0,0 +1,90 @@
+package detour
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	M "github.com/sagernet/sing/common/metadata"
+)
+
+func TestRuleMatch(t *testing.T) {
+	for _, test := range []struct {
+		options     RuleOptions
+		destination string
+		match       bool
+	}{
+		{RuleOptions{Domain: []string{"Example.com."}}, "example.com:443", true},
+		{RuleOptions{Domain: []string{"example.com"}}, "EXAMPLE.COM.:443", true},
+		{RuleOptions{Domain: []string{"example.com"}}, "www.example.com:443", false},
+		{RuleOptions{Domain: []string{"example.com"}}, "93.184.216.34:443", false},
+		{RuleOptions{DomainSuffix: []string{".example.com"}}, "example.com:443", true},
+		{RuleOptions{DomainSuffix: []string{"example.com"}}, "a.b.example.com:80", true},
+		{RuleOptions{DomainSuffix: []string{"example.com"}}, "badexample.com:80", false},
+		{RuleOptions{Domain: []string{"example.org"}, DomainSuffix: []string{"example.com"}}, "example.org:80", true},
+		{RuleOptions{IPCIDR: []string{"10.0.0.0/8"}}, "10.1.2.3:22", true},
+		{RuleOptions{IPCIDR: []string{"10.0.0.0/8"}}, "[::ffff:10.1.2.3]:22", true},
+		{RuleOptions{IPCIDR: []string{"10.0.0.0/8"}}, "11.0.0.1:22", false},
+		{RuleOptions{IPCIDR: []string{"10.0.0.0/8"}}, "example.com:22", false},
+		{RuleOptions{IPCIDR: []string{"2001:db8::1"}}, "[2001:db8::1]:443", true},
+		{RuleOptions{IPCIDR: []string{"2001:db8::1"}}, "[2001:db8::2]:443", false},
+		{RuleOptions{Port: []uint16{53}}, "8.8.8.8:53", true},
+		{RuleOptions{Port: []uint16{53}}, "8.8.8.8:853", false},
+		{RuleOptions{DomainSuffix: []string{"example.com"}, Port: []uint16{443}}, "www.example.com:443", true},
+		{RuleOptions{DomainSuffix: []string{"example.com"}, Port: []uint16{443}}, "www.example.com:80", false},
+	} {
+		rule, err := NewRule(test.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rule.Match(M.ParseSocksaddr(test.destination)) != test.match {
+			t.Errorf("%+v: expected match %v for %s", test.options, test.match, test.destination)
+		}
+	}
+}
+
+func TestNewRuleInvalid(t *testing.T) {
+	for _, options := range []RuleOptions{
+		{},
+		{IPCIDR: []string{"10.0.0.0/33"}},
+		{IPCIDR: []string{"example.com"}},
+	} {
+		_, err := NewRule(options)
+		if err == nil {
+			t.Errorf("%+v: expected error", options)
+		}
+	}
+}
+
+type nopOutbound struct{}
+
+func (nopOutbound) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	return nil, nil
+}
+
+func (nopOutbound) ListenPacket(ctx context.Context, destination M.Socksaddr) (net.PacketConn, error) {
+	return nil, nil
+}
+
+func TestRouterMatch(t *testing.T) {
+	rule, err := NewRule(RuleOptions{DomainSuffix: []string{"example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		router      *Router
+		destination M.Socksaddr
+		match       bool
+	}{
+		{&Router{}, M.ParseSocksaddr("example.com:443"), false},
+		{&Router{detour: nopOutbound{}}, M.ParseSocksaddr("example.org:443"), true},
+		{&Router{detour: nopOutbound{}}, M.Socksaddr{}, true},
+		{&Router{detour: nopOutbound{}, rules: []*Rule{rule}}, M.ParseSocksaddr("www.example.com:443"), true},
+		{&Router{detour: nopOutbound{}, rules: []*Rule{rule}}, M.ParseSocksaddr("example.org:443"), false},
+		{&Router{detour: nopOutbound{}, rules: []*Rule{rule}}, M.Socksaddr{}, false},
+	} {
+		if test.router.match(test.destination) != test.match {
+			t.Errorf("expected match %v for %s with %d rules", test.match, test.destination, len(test.router.rules))
+		}
+	}
+}
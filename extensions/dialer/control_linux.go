@@ -0,0 +1,9 @@
+package dialer
+
+import (
+	"syscall"
+)
+
+func bindToInterface(fd uintptr, network string, name string) error {
+	return syscall.BindToDevice(int(fd), name)
+}
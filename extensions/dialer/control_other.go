@@ -0,0 +1,11 @@
+//go:build !linux
+
+package dialer
+
+import (
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+func bindToInterface(fd uintptr, network string, name string) error {
+	return E.New("bind interface is only available on linux")
+}
@@ -0,0 +1,262 @@
+package dialer
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sagernet/sing-tools/extensions/dns"
+	"github.com/sagernet/sing/common"
+	"github.com/sagernet/sing/common/buf"
+	"github.com/sagernet/sing/common/bufio"
+	E "github.com/sagernet/sing/common/exceptions"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+	"github.com/sagernet/sing/common/redir"
+	"github.com/sagernet/sing/transport/system"
+)
+
+type Options struct {
+	BindInterface    string       `json:"bind_interface"`
+	Inet4BindAddress string       `json:"inet4_bind_address"`
+	Inet6BindAddress string       `json:"inet6_bind_address"`
+	FWMark           int          `json:"fwmark"`
+	TCPFastOpen      bool         `json:"fast_open"`
+	DialTimeout      int64        `json:"dial_timeout"`
+	DNS              *dns.Options `json:"dns"`
+}
+
+var _ N.ContextDialer = (*Dialer)(nil)
+
+type Dialer struct {
+	tcp4      net.Dialer
+	tcp6      net.Dialer
+	udp4      net.Dialer
+	udp6      net.Dialer
+	listener  net.ListenConfig
+	bind4     netip.Addr
+	bind6     netip.Addr
+	bindUDP   string
+	timeout   time.Duration
+	resolver  *dns.Client
+	anyFamily bool
+}
+
+func New(options Options) (*Dialer, error) {
+	d := &Dialer{
+		timeout: 5 * time.Second,
+	}
+	if options.DialTimeout > 0 {
+		d.timeout = time.Duration(options.DialTimeout) * time.Second
+	}
+	if options.DNS != nil {
+		resolver, err := dns.NewClient((*systemDialer)(d), *options.DNS)
+		if err != nil {
+			return nil, err
+		}
+		d.resolver = resolver
+	}
+	if options.Inet4BindAddress != "" {
+		addr, err := netip.ParseAddr(options.Inet4BindAddress)
+		if err != nil || !addr.Is4() {
+			return nil, E.New("bad inet4 bind address ", options.Inet4BindAddress)
+		}
+		d.bind4 = addr
+	}
+	if options.Inet6BindAddress != "" {
+		addr, err := netip.ParseAddr(options.Inet6BindAddress)
+		if err != nil || !addr.Is6() {
+			return nil, E.New("bad inet6 bind address ", options.Inet6BindAddress)
+		}
+		d.bind6 = addr
+	}
+	d.anyFamily = d.bind4.IsValid() == d.bind6.IsValid()
+
+	tcpControl := newControl(options.BindInterface, options.FWMark, options.TCPFastOpen)
+	udpControl := newControl(options.BindInterface, options.FWMark, false)
+
+	// the local address of a dialer must match the network, so TCP and UDP dialers are kept apart
+	d.tcp4 = net.Dialer{Timeout: d.timeout, Control: tcpControl}
+	d.tcp6 = net.Dialer{Timeout: d.timeout, Control: tcpControl}
+	d.udp4 = net.Dialer{Timeout: d.timeout, Control: udpControl}
+	d.udp6 = net.Dialer{Timeout: d.timeout, Control: udpControl}
+	if d.bind4.IsValid() {
+		d.tcp4.LocalAddr = &net.TCPAddr{IP: d.bind4.AsSlice()}
+		d.udp4.LocalAddr = &net.UDPAddr{IP: d.bind4.AsSlice()}
+	}
+	if d.bind6.IsValid() {
+		d.tcp6.LocalAddr = &net.TCPAddr{IP: d.bind6.AsSlice()}
+		d.udp6.LocalAddr = &net.UDPAddr{IP: d.bind6.AsSlice()}
+	}
+	d.listener = net.ListenConfig{Control: udpControl}
+
+	// a single UDP socket can only be bound to one family, so with both (or neither)
+	// bind addresses set the source address is left to the routing table.
+	switch {
+	case d.bind4.IsValid() && !d.bind6.IsValid():
+		d.bindUDP = netip.AddrPortFrom(d.bind4, 0).String()
+	case d.bind6.IsValid() && !d.bind4.IsValid():
+		d.bindUDP = netip.AddrPortFrom(d.bind6, 0).String()
+	}
+	return d, nil
+}
+
+func (d *Dialer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	if !destination.IsFqdn() {
+		return d.dialAddr(ctx, network, destination)
+	}
+	addrs, err := d.lookup(ctx, d.resolver, destination.Fqdn)
+	if err != nil {
+		return nil, err
+	}
+	return dns.DialParallel(ctx, (*systemDialer)(d), network, addrs, destination.Port)
+}
+
+func (d *Dialer) dialAddr(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	addr := destination.Addr.Unmap()
+	isUDP := strings.HasPrefix(network, "udp")
+	if addr.Is4() {
+		if !d.anyFamily && !d.bind4.IsValid() {
+			return nil, E.New("dial ", destination, ": no inet4 bind address")
+		}
+		dialer := &d.tcp4
+		if isUDP {
+			dialer = &d.udp4
+		}
+		return dialer.DialContext(ctx, networkFamily(network, "4"), destination.String())
+	} else {
+		if !d.anyFamily && !d.bind6.IsValid() {
+			return nil, E.New("dial ", destination, ": no inet6 bind address")
+		}
+		dialer := &d.tcp6
+		if isUDP {
+			dialer = &d.udp6
+		}
+		return dialer.DialContext(ctx, networkFamily(network, "6"), destination.String())
+	}
+}
+
+func (d *Dialer) lookup(ctx context.Context, resolver *dns.Client, domain string) ([]netip.Addr, error) {
+	var (
+		addrs []netip.Addr
+		err   error
+	)
+	if resolver != nil {
+		addrs, err = resolver.Lookup(ctx, domain)
+	} else {
+		addrs, err = net.DefaultResolver.LookupNetIP(ctx, "ip", domain)
+	}
+	if err != nil {
+		return nil, err
+	}
+	addrs = d.filterAddrs(addrs)
+	if len(addrs) == 0 {
+		return nil, E.New("no usable address for ", domain)
+	}
+	return addrs, nil
+}
+
+func (d *Dialer) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	network := "udp"
+	if d.bind4.IsValid() && !d.bind6.IsValid() {
+		network = "udp4"
+	} else if d.bind6.IsValid() && !d.bind4.IsValid() {
+		network = "udp6"
+	}
+	conn, err := d.listener.ListenPacket(ctx, network, d.bindUDP)
+	if err != nil {
+		return nil, err
+	}
+	if d.resolver == nil {
+		return conn, nil
+	}
+	return &resolvePacketConn{bufio.NewPacketConn(conn), d}, nil
+}
+
+func (d *Dialer) filterAddrs(addrs []netip.Addr) []netip.Addr {
+	if d.anyFamily {
+		return addrs
+	}
+	return common.Filter(addrs, func(it netip.Addr) bool {
+		if it.Unmap().Is4() {
+			return d.bind4.IsValid()
+		}
+		return d.bind6.IsValid()
+	})
+}
+
+func networkFamily(network string, family string) string {
+	if strings.HasSuffix(network, "4") || strings.HasSuffix(network, "6") {
+		return network
+	}
+	return network + family
+}
+
+func newControl(bindInterface string, fwmark int, fastOpen bool) func(network, address string, conn syscall.RawConn) error {
+	if bindInterface == "" && fwmark == 0 && !fastOpen {
+		return nil
+	}
+	return func(network, address string, conn syscall.RawConn) error {
+		var innerErr error
+		err := conn.Control(func(fd uintptr) {
+			if bindInterface != "" {
+				innerErr = bindToInterface(fd, network, bindInterface)
+				if innerErr != nil {
+					return
+				}
+			}
+			if fwmark > 0 {
+				innerErr = redir.FWMark(fd, fwmark)
+				if innerErr != nil {
+					return
+				}
+			}
+			if fastOpen && strings.HasPrefix(network, "tcp") {
+				innerErr = system.TCPFastOpen(fd)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return innerErr
+	}
+}
+
+// systemDialer resolves domains with the system resolver, so that dns servers
+// configured by name do not loop back into the dialer's own resolver.
+type systemDialer Dialer
+
+func (d *systemDialer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	if !destination.IsFqdn() {
+		return (*Dialer)(d).dialAddr(ctx, network, destination)
+	}
+	addrs, err := (*Dialer)(d).lookup(ctx, nil, destination.Fqdn)
+	if err != nil {
+		return nil, err
+	}
+	return dns.DialParallel(ctx, d, network, addrs, destination.Port)
+}
+
+type resolvePacketConn struct {
+	N.NetPacketConn
+	dialer *Dialer
+}
+
+func (c *resolvePacketConn) WritePacket(buffer *buf.Buffer, destination M.Socksaddr) error {
+	if destination.IsFqdn() {
+		addrs, err := c.dialer.lookup(context.Background(), c.dialer.resolver, destination.Fqdn)
+		if err != nil {
+			buffer.Release()
+			return err
+		}
+		destination = M.SocksaddrFromAddrPort(addrs[0], destination.Port)
+	}
+	return c.NetPacketConn.WritePacket(buffer, destination)
+}
+
+func (c *resolvePacketConn) Upstream() any {
+	return c.NetPacketConn
+}
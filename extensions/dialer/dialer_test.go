@@ -0,0 +1,141 @@
+package dialer
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	mDNS "github.com/miekg/dns"
+	"github.com/sagernet/sing-tools/extensions/dns"
+	M "github.com/sagernet/sing/common/metadata"
+)
+
+func TestDialUDPWithBindAddress(t *testing.T) {
+	server, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go func() {
+		buffer := make([]byte, 64)
+		n, addr, err := server.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		server.WriteTo(buffer[:n], addr)
+	}()
+
+	dialer, err := New(Options{Inet4BindAddress: "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	destination := M.SocksaddrFromNet(server.LocalAddr())
+	conn, err := dialer.DialContext(context.Background(), "udp", destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if local := conn.LocalAddr().(*net.UDPAddr); !local.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatal("unexpected local address ", local)
+	}
+	_, err = conn.Write([]byte("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 64)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buffer[:n]) != "ping" {
+		t.Fatal("unexpected response ", string(buffer[:n]))
+	}
+}
+
+func TestDialTCPWithBindAddress(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	dialer, err := New(Options{Inet4BindAddress: "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.DialContext(context.Background(), "tcp", M.SocksaddrFromNet(listener.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if local := conn.LocalAddr().(*net.TCPAddr); !local.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatal("unexpected local address ", local)
+	}
+}
+
+func TestDialWithoutFamilyBindAddress(t *testing.T) {
+	dialer, err := New(Options{Inet4BindAddress: "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dialer.DialContext(context.Background(), "udp", M.SocksaddrFromNetIP(netip.MustParseAddrPort("[::1]:53")))
+	if err == nil {
+		t.Fatal("expected error dialing inet6 with only an inet4 bind address")
+	}
+}
+
+// TestDialerResolverWithBindAddress resolves through the dialer's own UDP DNS client
+// while a bind address is set.
+func TestDialerResolverWithBindAddress(t *testing.T) {
+	packetConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &mDNS.Server{PacketConn: packetConn, Handler: mDNS.HandlerFunc(func(w mDNS.ResponseWriter, request *mDNS.Msg) {
+		response := new(mDNS.Msg)
+		response.SetReply(request)
+		question := request.Question[0]
+		if question.Qtype == mDNS.TypeA {
+			response.Answer = append(response.Answer, &mDNS.A{
+				Hdr: mDNS.RR_Header{Name: question.Name, Rrtype: mDNS.TypeA, Class: mDNS.ClassINET, Ttl: 60},
+				A:   net.ParseIP("127.0.0.1"),
+			})
+		}
+		w.WriteMsg(response)
+	})}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	d, err := New(Options{
+		Inet4BindAddress: "127.0.0.1",
+		DNS:              &dns.Options{Servers: []string{packetConn.LocalAddr().String()}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+	conn, err := d.DialContext(context.Background(), "tcp", M.ParseSocksaddrHostPort("test.example", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
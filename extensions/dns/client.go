@@ -0,0 +1,120 @@
+package dns
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/sagernet/sing/common"
+	"github.com/sagernet/sing/common/cache"
+	E "github.com/sagernet/sing/common/exceptions"
+	N "github.com/sagernet/sing/common/network"
+	"github.com/sagernet/sing/common/task"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type Options struct {
+	Servers      []string `json:"servers"`
+	Strategy     string   `json:"strategy"`
+	DisableCache bool     `json:"disable_cache"`
+}
+
+type Client struct {
+	transports []Transport
+	strategy   Strategy
+	cache      *cache.LruCache[question, []netip.Addr]
+}
+
+type question struct {
+	name  string
+	qType dnsmessage.Type
+}
+
+func NewClient(dialer N.ContextDialer, options Options) (*Client, error) {
+	strategy, err := ParseStrategy(options.Strategy)
+	if err != nil {
+		return nil, err
+	}
+	servers := options.Servers
+	if len(servers) == 0 {
+		servers = []string{"local"}
+	}
+	c := &Client{
+		strategy: strategy,
+	}
+	for _, server := range servers {
+		transport, err := NewTransport(dialer, server)
+		if err != nil {
+			return nil, E.Cause(err, "parse dns server ", server)
+		}
+		c.transports = append(c.transports, transport)
+	}
+	if !options.DisableCache {
+		c.cache = cache.New(cache.WithSize[question, []netip.Addr](2048))
+	}
+	return c, nil
+}
+
+func (c *Client) Strategy() Strategy {
+	return c.strategy
+}
+
+// Lookup resolves domain to addresses ordered by the configured strategy.
+func (c *Client) Lookup(ctx context.Context, domain string) ([]netip.Addr, error) {
+	domain = strings.TrimSuffix(domain, ".")
+	if addr, err := netip.ParseAddr(domain); err == nil {
+		return []netip.Addr{addr}, nil
+	}
+	var (
+		inet4, inet6       []netip.Addr
+		inet4Err, inet6Err error
+	)
+	var lookups []func() error
+	if c.strategy != StrategyIPv6Only {
+		lookups = append(lookups, func() error {
+			inet4, inet4Err = c.lookupType(ctx, domain, dnsmessage.TypeA)
+			return nil
+		})
+	}
+	if c.strategy != StrategyIPv4Only {
+		lookups = append(lookups, func() error {
+			inet6, inet6Err = c.lookupType(ctx, domain, dnsmessage.TypeAAAA)
+			return nil
+		})
+	}
+	_ = task.Run(ctx, lookups...)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	addrs := sortAddrs(c.strategy, inet4, inet6)
+	if len(addrs) == 0 {
+		if err := common.AnyError(inet4Err, inet6Err); err != nil {
+			return nil, E.Cause(err, "lookup ", domain)
+		}
+		return nil, E.New("lookup ", domain, ": no such host")
+	}
+	return addrs, nil
+}
+
+func (c *Client) lookupType(ctx context.Context, domain string, qType dnsmessage.Type) ([]netip.Addr, error) {
+	key := question{domain, qType}
+	if c.cache != nil {
+		if addrs, loaded := c.cache.Load(key); loaded {
+			return addrs, nil
+		}
+	}
+	var errors []error
+	for _, transport := range c.transports {
+		addrs, ttl, err := transport.Lookup(ctx, domain, qType)
+		if err != nil {
+			errors = append(errors, err)
+			continue
+		}
+		if c.cache != nil && ttl > 0 {
+			c.cache.StoreWithExpire(key, addrs, time.Now().Add(time.Duration(ttl)*time.Second))
+		}
+		return addrs, nil
+	}
+	return nil, common.AnyError(errors...)
+}
@@ -0,0 +1,193 @@
+package dns
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+	M "github.com/sagernet/sing/common/metadata"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type testDialer struct{}
+
+func (d testDialer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, destination.String())
+}
+
+type testServer struct {
+	udpAddr string
+	tcpAddr string
+	queries int32
+}
+
+func (s *testServer) reply(request *dns.Msg) *dns.Msg {
+	atomic.AddInt32(&s.queries, 1)
+	response := new(dns.Msg)
+	response.SetReply(request)
+	question := request.Question[0]
+	if question.Name != "test.example." {
+		response.Rcode = dns.RcodeNameError
+		return response
+	}
+	header := dns.RR_Header{Name: question.Name, Rrtype: question.Qtype, Class: dns.ClassINET, Ttl: 60}
+	switch question.Qtype {
+	case dns.TypeA:
+		response.Answer = append(response.Answer,
+			&dns.A{Hdr: header, A: net.ParseIP("127.0.0.1")},
+			&dns.A{Hdr: header, A: net.ParseIP("192.0.2.1")})
+	case dns.TypeAAAA:
+		response.Answer = append(response.Answer, &dns.AAAA{Hdr: header, AAAA: net.ParseIP("::1")})
+	}
+	return response
+}
+
+func startServer(t *testing.T) *testServer {
+	server := new(testServer)
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, request *dns.Msg) {
+		w.WriteMsg(server.reply(request))
+	})
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	udpServer := &dns.Server{PacketConn: packetConn, Handler: handler}
+	tcpServer := &dns.Server{Listener: listener, Handler: handler}
+	go udpServer.ActivateAndServe()
+	go tcpServer.ActivateAndServe()
+	t.Cleanup(func() {
+		udpServer.Shutdown()
+		tcpServer.Shutdown()
+	})
+	server.udpAddr = packetConn.LocalAddr().String()
+	server.tcpAddr = listener.Addr().String()
+	return server
+}
+
+func checkAddrs(t *testing.T, addrs []netip.Addr, expected ...string) {
+	t.Helper()
+	if len(addrs) != len(expected) {
+		t.Fatal("expected ", expected, ", got ", addrs)
+	}
+	for i, addr := range addrs {
+		if addr.String() != expected[i] {
+			t.Fatal("expected ", expected, ", got ", addrs)
+		}
+	}
+}
+
+func TestTransportUDP(t *testing.T) {
+	server := startServer(t)
+	transport, err := NewTransport(testDialer{}, server.udpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs, ttl, err := transport.Lookup(context.Background(), "test.example", dnsmessage.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAddrs(t, addrs, "127.0.0.1", "192.0.2.1")
+	if ttl != 60 {
+		t.Fatal("unexpected ttl ", ttl)
+	}
+}
+
+func TestTransportTCP(t *testing.T) {
+	server := startServer(t)
+	transport, err := NewTransport(testDialer{}, "tcp://"+server.tcpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs, _, err := transport.Lookup(context.Background(), "test.example", dnsmessage.TypeAAAA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAddrs(t, addrs, "::1")
+}
+
+func TestTransportHTTPS(t *testing.T) {
+	server := new(testServer)
+	httpServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dns-query" || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		content, _ := io.ReadAll(r.Body)
+		request := new(dns.Msg)
+		err := request.Unpack(content)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		response, _ := server.reply(request).Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(response)
+	}))
+	defer httpServer.Close()
+	serverURL, _ := url.Parse(httpServer.URL)
+	exchanger := newHTTPSExchanger(testDialer{}, serverURL)
+	exchanger.client.Transport.(*http.Transport).TLSClientConfig = httpServer.Client().Transport.(*http.Transport).TLSClientConfig
+	transport := &exchangeTransport{exchange: exchanger.Exchange}
+	addrs, _, err := transport.Lookup(context.Background(), "test.example", dnsmessage.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAddrs(t, addrs, "127.0.0.1", "192.0.2.1")
+}
+
+func TestClientStrategy(t *testing.T) {
+	server := startServer(t)
+	for _, it := range []struct {
+		strategy string
+		expected []string
+	}{
+		{"", []string{"::1", "127.0.0.1", "192.0.2.1"}},
+		{"prefer_ipv4", []string{"127.0.0.1", "::1", "192.0.2.1"}},
+		{"prefer_ipv6", []string{"::1", "127.0.0.1", "192.0.2.1"}},
+		{"ipv4_only", []string{"127.0.0.1", "192.0.2.1"}},
+		{"ipv6_only", []string{"::1"}},
+	} {
+		client, err := NewClient(testDialer{}, Options{Servers: []string{server.udpAddr}, Strategy: it.strategy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs, err := client.Lookup(context.Background(), "test.example")
+		if err != nil {
+			t.Fatal(it.strategy, ": ", err)
+		}
+		checkAddrs(t, addrs, it.expected...)
+	}
+}
+
+func TestClientCacheAndNameError(t *testing.T) {
+	server := startServer(t)
+	client, err := NewClient(testDialer{}, Options{Servers: []string{server.udpAddr}, Strategy: "ipv4_only"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		_, err = client.Lookup(context.Background(), "test.example")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if queries := atomic.LoadInt32(&server.queries); queries != 1 {
+		t.Fatal("expected cached answer, got ", queries, " queries")
+	}
+	_, err = client.Lookup(context.Background(), "missing.example")
+	if err == nil {
+		t.Fatal("expected error for missing name")
+	}
+}
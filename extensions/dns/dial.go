@@ -0,0 +1,73 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/sagernet/sing/common"
+	E "github.com/sagernet/sing/common/exceptions"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+const attemptDelay = 250 * time.Millisecond
+
+// DialParallel connects to the first reachable address, starting a new attempt every
+// 250ms or as soon as the previous one fails (happy eyeballs, RFC 8305).
+func DialParallel(ctx context.Context, dialer N.ContextDialer, network string, addrs []netip.Addr, port uint16) (net.Conn, error) {
+	if len(addrs) == 0 {
+		return nil, E.New("no address to dial")
+	} else if len(addrs) == 1 {
+		return dialer.DialContext(ctx, network, M.SocksaddrFromAddrPort(addrs[0], port))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan dialResult)
+	var (
+		next    int
+		pending int
+		errors  []error
+	)
+	nextAttempt := time.Now()
+	for next < len(addrs) || pending > 0 {
+		var attempt <-chan time.Time
+		if next < len(addrs) {
+			attempt = time.After(time.Until(nextAttempt))
+		}
+		select {
+		case <-attempt:
+			destination := M.SocksaddrFromAddrPort(addrs[next], port)
+			next++
+			pending++
+			nextAttempt = time.Now().Add(attemptDelay)
+			go func() {
+				conn, err := dialer.DialContext(ctx, network, destination)
+				select {
+				case results <- dialResult{conn, err}:
+				case <-ctx.Done():
+					if conn != nil {
+						conn.Close()
+					}
+				}
+			}()
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				return result.conn, nil
+			}
+			errors = append(errors, result.err)
+			nextAttempt = time.Now()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, common.AnyError(errors...)
+}
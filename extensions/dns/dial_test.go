@@ -0,0 +1,174 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	E "github.com/sagernet/sing/common/exceptions"
+	M "github.com/sagernet/sing/common/metadata"
+)
+
+type dialBehavior struct {
+	delay time.Duration
+	fail  bool
+}
+
+// scriptedDialer records the order and time of attempts and answers each address as
+// scripted, attempts without script hang until canceled.
+type scriptedDialer struct {
+	start    time.Time
+	behavior map[netip.Addr]dialBehavior
+
+	access   sync.Mutex
+	attempts []netip.Addr
+	started  map[netip.Addr]time.Duration
+	canceled map[netip.Addr]bool
+}
+
+func newScriptedDialer(behavior map[netip.Addr]dialBehavior) *scriptedDialer {
+	return &scriptedDialer{
+		start:    time.Now(),
+		behavior: behavior,
+		started:  make(map[netip.Addr]time.Duration),
+		canceled: make(map[netip.Addr]bool),
+	}
+}
+
+func (d *scriptedDialer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	addr := destination.Addr
+	d.access.Lock()
+	d.attempts = append(d.attempts, addr)
+	d.started[addr] = time.Since(d.start)
+	d.access.Unlock()
+	behavior, loaded := d.behavior[addr]
+	if !loaded {
+		<-ctx.Done()
+		d.access.Lock()
+		d.canceled[addr] = true
+		d.access.Unlock()
+		return nil, ctx.Err()
+	}
+	select {
+	case <-time.After(behavior.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if behavior.fail {
+		return nil, E.New("refused ", addr)
+	}
+	client, server := net.Pipe()
+	server.Close()
+	return &addrConn{client, destination}, nil
+}
+
+type addrConn struct {
+	net.Conn
+	destination M.Socksaddr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr {
+	return c.destination.TCPAddr()
+}
+
+func TestDialParallelFallback(t *testing.T) {
+	hanging := netip.MustParseAddr("2001:db8::1")
+	refused := netip.MustParseAddr("2001:db8::2")
+	reachable := netip.MustParseAddr("192.0.2.1")
+	dialer := newScriptedDialer(map[netip.Addr]dialBehavior{
+		refused:   {fail: true},
+		reachable: {},
+	})
+	conn, err := DialParallel(context.Background(), dialer, "tcp", []netip.Addr{hanging, refused, reachable}, 443)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if remote := M.SocksaddrFromNet(conn.RemoteAddr()).Addr; remote != reachable {
+		t.Fatal("expected connection to ", reachable, ", got ", remote)
+	}
+	dialer.access.Lock()
+	defer dialer.access.Unlock()
+	if len(dialer.attempts) != 3 || dialer.attempts[0] != hanging || dialer.attempts[1] != refused || dialer.attempts[2] != reachable {
+		t.Fatal("unexpected attempt order ", dialer.attempts)
+	}
+	// the second attempt waits for the attempt delay, the third starts once the second fails
+	if dialer.started[refused] < attemptDelay {
+		t.Fatal("second attempt started after ", dialer.started[refused])
+	}
+	if gap := dialer.started[reachable] - dialer.started[refused]; gap >= attemptDelay {
+		t.Fatal("third attempt waited ", gap, " after the second failed")
+	}
+}
+
+func TestDialParallelPrefersFirstAddress(t *testing.T) {
+	first := netip.MustParseAddr("2001:db8::1")
+	second := netip.MustParseAddr("192.0.2.1")
+	dialer := newScriptedDialer(map[netip.Addr]dialBehavior{
+		first:  {delay: 50 * time.Millisecond},
+		second: {},
+	})
+	conn, err := DialParallel(context.Background(), dialer, "tcp", []netip.Addr{first, second}, 443)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if remote := M.SocksaddrFromNet(conn.RemoteAddr()).Addr; remote != first {
+		t.Fatal("expected connection to ", first, ", got ", remote)
+	}
+	dialer.access.Lock()
+	defer dialer.access.Unlock()
+	if len(dialer.attempts) != 1 {
+		t.Fatal("expected no fallback before the attempt delay, got ", dialer.attempts)
+	}
+}
+
+func TestDialParallelCancelsSlowerAttempts(t *testing.T) {
+	hanging := netip.MustParseAddr("2001:db8::1")
+	reachable := netip.MustParseAddr("192.0.2.1")
+	dialer := newScriptedDialer(map[netip.Addr]dialBehavior{
+		reachable: {},
+	})
+	conn, err := DialParallel(context.Background(), dialer, "tcp", []netip.Addr{hanging, reachable}, 443)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		dialer.access.Lock()
+		canceled := dialer.canceled[hanging]
+		dialer.access.Unlock()
+		if canceled {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("hanging attempt not canceled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDialParallelAllFailed(t *testing.T) {
+	first := netip.MustParseAddr("2001:db8::1")
+	second := netip.MustParseAddr("192.0.2.1")
+	dialer := newScriptedDialer(map[netip.Addr]dialBehavior{
+		first:  {fail: true},
+		second: {fail: true},
+	})
+	start := time.Now()
+	_, err := DialParallel(context.Background(), dialer, "tcp", []netip.Addr{first, second}, 443)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if elapsed := time.Since(start); elapsed >= attemptDelay {
+		t.Fatal("expected failed attempts to fall back immediately, took ", elapsed)
+	}
+	_, err = DialParallel(context.Background(), dialer, "tcp", nil, 443)
+	if err == nil {
+		t.Fatal("expected error without addresses")
+	}
+}
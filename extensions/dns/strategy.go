@@ -0,0 +1,57 @@
+package dns
+
+import (
+	"net/netip"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+type Strategy uint8
+
+const (
+	StrategyAsIs Strategy = iota
+	StrategyPreferIPv4
+	StrategyPreferIPv6
+	StrategyIPv4Only
+	StrategyIPv6Only
+)
+
+func ParseStrategy(strategy string) (Strategy, error) {
+	switch strategy {
+	case "":
+		return StrategyAsIs, nil
+	case "prefer_ipv4":
+		return StrategyPreferIPv4, nil
+	case "prefer_ipv6":
+		return StrategyPreferIPv6, nil
+	case "ipv4_only":
+		return StrategyIPv4Only, nil
+	case "ipv6_only":
+		return StrategyIPv6Only, nil
+	default:
+		return StrategyAsIs, E.New("unknown domain strategy ", strategy)
+	}
+}
+
+// sortAddrs interleaves both families starting with the preferred one, as described in RFC 8305.
+func sortAddrs(strategy Strategy, inet4 []netip.Addr, inet6 []netip.Addr) []netip.Addr {
+	primary, secondary := inet6, inet4
+	switch strategy {
+	case StrategyPreferIPv4:
+		primary, secondary = inet4, inet6
+	case StrategyIPv4Only:
+		return inet4
+	case StrategyIPv6Only:
+		return inet6
+	}
+	addrs := make([]netip.Addr, 0, len(primary)+len(secondary))
+	for i := 0; i < len(primary) || i < len(secondary); i++ {
+		if i < len(primary) {
+			addrs = append(addrs, primary[i])
+		}
+		if i < len(secondary) {
+			addrs = append(addrs, secondary[i])
+		}
+	}
+	return addrs
+}
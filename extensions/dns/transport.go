@@ -0,0 +1,280 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sagernet/sing/common"
+	"github.com/sagernet/sing/common/buf"
+	E "github.com/sagernet/sing/common/exceptions"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const exchangeTimeout = 5 * time.Second
+
+type Transport interface {
+	Lookup(ctx context.Context, domain string, qType dnsmessage.Type) (addrs []netip.Addr, ttl uint32, err error)
+}
+
+// NewTransport parses a server address such as 1.1.1.1, tcp://1.1.1.1, tls://dns.google,
+// https://1.1.1.1/dns-query or local (the system resolver).
+func NewTransport(dialer N.ContextDialer, server string) (Transport, error) {
+	if server == "local" {
+		return new(localTransport), nil
+	}
+	if !strings.Contains(server, "://") {
+		server = "udp://" + server
+	}
+	serverURL, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	switch serverURL.Scheme {
+	case "udp":
+		destination, err := parseServer(serverURL, 53)
+		if err != nil {
+			return nil, err
+		}
+		return &exchangeTransport{exchange: (&udpExchanger{dialer, destination}).Exchange}, nil
+	case "tcp":
+		destination, err := parseServer(serverURL, 53)
+		if err != nil {
+			return nil, err
+		}
+		return &exchangeTransport{exchange: (&tcpExchanger{dialer: dialer, server: destination}).Exchange}, nil
+	case "tls":
+		destination, err := parseServer(serverURL, 853)
+		if err != nil {
+			return nil, err
+		}
+		return &exchangeTransport{exchange: (&tcpExchanger{dialer: dialer, server: destination, tlsConfig: &tls.Config{
+			ServerName: destination.AddrString(),
+		}}).Exchange}, nil
+	case "https":
+		return &exchangeTransport{exchange: newHTTPSExchanger(dialer, serverURL).Exchange}, nil
+	default:
+		return nil, E.New("unknown dns server scheme ", serverURL.Scheme)
+	}
+}
+
+func parseServer(serverURL *url.URL, defaultPort uint16) (M.Socksaddr, error) {
+	port := defaultPort
+	if portString := serverURL.Port(); portString != "" {
+		parsedPort, err := strconv.ParseUint(portString, 10, 16)
+		if err != nil {
+			return M.Socksaddr{}, E.Cause(err, "bad port")
+		}
+		port = uint16(parsedPort)
+	}
+	if serverURL.Hostname() == "" {
+		return M.Socksaddr{}, E.New("missing server address")
+	}
+	return M.ParseSocksaddrHostPort(serverURL.Hostname(), port), nil
+}
+
+type localTransport struct{}
+
+func (t *localTransport) Lookup(ctx context.Context, domain string, qType dnsmessage.Type) ([]netip.Addr, uint32, error) {
+	network := "ip4"
+	if qType == dnsmessage.TypeAAAA {
+		network = "ip6"
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, network, domain)
+	if err != nil {
+		return nil, 0, err
+	}
+	// the system resolver does not expose ttl, use a short fixed one
+	return common.Map(addrs, netip.Addr.Unmap), 60, nil
+}
+
+type exchangeTransport struct {
+	exchange func(ctx context.Context, message []byte) ([]byte, error)
+}
+
+func (t *exchangeTransport) Lookup(ctx context.Context, domain string, qType dnsmessage.Type) ([]netip.Addr, uint32, error) {
+	name, err := dnsmessage.NewName(domain + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+	message := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               uint16(rand.Uint32()),
+			RecursionDesired: true,
+		},
+		Questions: []dnsmessage.Question{{
+			Name:  name,
+			Type:  qType,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	request, err := message.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, loaded := ctx.Deadline(); !loaded {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, exchangeTimeout)
+		defer cancel()
+	}
+	response, err := t.exchange(ctx, request)
+	if err != nil {
+		return nil, 0, err
+	}
+	var answer dnsmessage.Message
+	err = answer.Unpack(response)
+	if err != nil {
+		return nil, 0, E.Cause(err, "unpack dns response")
+	}
+	if answer.ID != message.ID {
+		return nil, 0, E.New("dns response id mismatch")
+	}
+	if answer.RCode != dnsmessage.RCodeSuccess && answer.RCode != dnsmessage.RCodeNameError {
+		return nil, 0, E.New("dns server returned ", answer.RCode)
+	}
+	var (
+		addrs []netip.Addr
+		ttl   uint32
+	)
+	for _, resource := range answer.Answers {
+		switch body := resource.Body.(type) {
+		case *dnsmessage.AResource:
+			addrs = append(addrs, netip.AddrFrom4(body.A))
+		case *dnsmessage.AAAAResource:
+			addrs = append(addrs, netip.AddrFrom16(body.AAAA))
+		default:
+			continue
+		}
+		if ttl == 0 || resource.Header.TTL < ttl {
+			ttl = resource.Header.TTL
+		}
+	}
+	return addrs, ttl, nil
+}
+
+type udpExchanger struct {
+	dialer N.ContextDialer
+	server M.Socksaddr
+}
+
+func (e *udpExchanger) Exchange(ctx context.Context, message []byte) ([]byte, error) {
+	conn, err := e.dialer.DialContext(ctx, "udp", e.server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, loaded := ctx.Deadline(); loaded {
+		conn.SetDeadline(deadline)
+	}
+	_, err = conn.Write(message)
+	if err != nil {
+		return nil, err
+	}
+	response := buf.Make(buf.UDPBufferSize)
+	n, err := conn.Read(response)
+	if err != nil {
+		return nil, err
+	}
+	return response[:n], nil
+}
+
+type tcpExchanger struct {
+	dialer    N.ContextDialer
+	server    M.Socksaddr
+	tlsConfig *tls.Config
+}
+
+func (e *tcpExchanger) Exchange(ctx context.Context, message []byte) ([]byte, error) {
+	conn, err := e.dialer.DialContext(ctx, "tcp", e.server)
+	if err != nil {
+		return nil, err
+	}
+	if e.tlsConfig != nil {
+		tlsConn := tls.Client(conn, e.tlsConfig)
+		err = tlsConn.HandshakeContext(ctx)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	defer conn.Close()
+	if deadline, loaded := ctx.Deadline(); loaded {
+		conn.SetDeadline(deadline)
+	}
+	request := make([]byte, 2+len(message))
+	binary.BigEndian.PutUint16(request, uint16(len(message)))
+	copy(request[2:], message)
+	_, err = conn.Write(request)
+	if err != nil {
+		return nil, err
+	}
+	var length uint16
+	err = binary.Read(conn, binary.BigEndian, &length)
+	if err != nil {
+		return nil, err
+	}
+	response := make([]byte, length)
+	_, err = io.ReadFull(conn, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+type httpsExchanger struct {
+	client *http.Client
+	url    string
+}
+
+func newHTTPSExchanger(dialer N.ContextDialer, serverURL *url.URL) *httpsExchanger {
+	if serverURL.Path == "" {
+		serverURL.Path = "/dns-query"
+	}
+	return &httpsExchanger{
+		client: &http.Client{
+			Transport: &http.Transport{
+				ForceAttemptHTTP2: true,
+				DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+					return dialer.DialContext(ctx, network, M.ParseSocksaddr(address))
+				},
+			},
+		},
+		url: serverURL.String(),
+	}
+}
+
+func (e *httpsExchanger) Exchange(ctx context.Context, message []byte) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(message))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/dns-message")
+	request.Header.Set("Accept", "application/dns-message")
+	response, err := e.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, E.New("HTTP ", response.StatusCode, ": ", string(content))
+	}
+	return content, nil
+}
@@ -0,0 +1,84 @@
+package publicip
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+
+	"github.com/miekg/dns"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+// dnsSource queries a name answered with the address of the client, e.g. myip.opendns.com
+// at resolver1.opendns.com, or the TXT record o-o.myaddr.l.google.com at ns1.google.com.
+type dnsSource struct {
+	server    string
+	name      string
+	queryType uint16
+}
+
+func newDNSSource(options SourceOptions) (*dnsSource, error) {
+	if options.Server == "" {
+		return nil, E.New("dns: missing server")
+	}
+	if options.Name == "" {
+		return nil, E.New("dns: missing name")
+	}
+	source := &dnsSource{
+		server: withDefaultPort(options.Server, "53"),
+		name:   dns.Fqdn(options.Name),
+	}
+	switch strings.ToUpper(options.QueryType) {
+	case "":
+	case "TXT":
+		source.queryType = dns.TypeTXT
+	default:
+		return nil, E.New("dns: unsupported query type ", options.QueryType)
+	}
+	return source, nil
+}
+
+func (s *dnsSource) Name() string {
+	return "dns " + s.name + " @" + s.server
+}
+
+func (s *dnsSource) Lookup(ctx context.Context, network string) (netip.Addr, error) {
+	client := &dns.Client{Net: "udp4"}
+	queryType := s.queryType
+	if network == NetworkIPv6 {
+		client.Net = "udp6"
+	}
+	if queryType == 0 {
+		queryType = dns.TypeA
+		if network == NetworkIPv6 {
+			queryType = dns.TypeAAAA
+		}
+	}
+	message := new(dns.Msg)
+	message.SetQuestion(s.name, queryType)
+	response, _, err := client.ExchangeContext(ctx, message, s.server)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if response.Rcode != dns.RcodeSuccess {
+		return netip.Addr{}, E.New("dns: ", dns.RcodeToString[response.Rcode])
+	}
+	for _, answer := range response.Answer {
+		var values []string
+		switch record := answer.(type) {
+		case *dns.A:
+			values = []string{record.A.String()}
+		case *dns.AAAA:
+			values = []string{record.AAAA.String()}
+		case *dns.TXT:
+			values = record.Txt
+		}
+		for _, value := range values {
+			addr, err := netip.ParseAddr(strings.TrimSpace(value))
+			if err == nil && addr.Unmap().Is4() == (network == NetworkIPv4) {
+				return addr, nil
+			}
+		}
+	}
+	return netip.Addr{}, E.New("dns: no address in response")
+}
@@ -0,0 +1,22 @@
+package publicip
+
+import (
+	"net/netip"
+
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/vishvananda/netlink"
+)
+
+func defaultGateway() (netip.Addr, error) {
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	for _, route := range routes {
+		if route.Dst == nil && route.Gw != nil {
+			gateway, _ := netip.AddrFromSlice(route.Gw)
+			return gateway.Unmap(), nil
+		}
+	}
+	return netip.Addr{}, E.New("no default route")
+}
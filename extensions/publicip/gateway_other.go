@@ -0,0 +1,13 @@
+//go:build !linux
+
+package publicip
+
+import (
+	"net/netip"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+func defaultGateway() (netip.Addr, error) {
+	return netip.Addr{}, E.New("default gateway discovery is only supported on linux, set server")
+}
@@ -0,0 +1,120 @@
+package publicip
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strings"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+// httpSource reads the address from a "what is my IP" endpoint, connecting over the
+// network looked up so that the endpoint sees the address of that family. Proxies are
+// not used, the endpoint would see the address of the proxy.
+type httpSource struct {
+	url       string
+	jsonField []string
+	pattern   *regexp.Regexp
+}
+
+func newHTTPSource(options SourceOptions) (*httpSource, error) {
+	if options.URL == "" {
+		return nil, E.New("http: missing url")
+	}
+	source := &httpSource{url: options.URL}
+	if options.JSONField != "" {
+		source.jsonField = strings.Split(options.JSONField, ".")
+	}
+	if options.Regexp != "" {
+		pattern, err := regexp.Compile(options.Regexp)
+		if err != nil {
+			return nil, E.Cause(err, "http: parse regexp")
+		}
+		source.pattern = pattern
+	}
+	return source, nil
+}
+
+func (s *httpSource) Name() string {
+	return "http " + s.url
+}
+
+func (s *httpSource) Lookup(ctx context.Context, network string) (netip.Addr, error) {
+	dialNetwork := "tcp4"
+	if network == NetworkIPv6 {
+		dialNetwork = "tcp6"
+	}
+	dialer := new(net.Dialer)
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, address string) (net.Conn, error) {
+				return dialer.DialContext(ctx, dialNetwork, address)
+			},
+		},
+	}
+	defer client.CloseIdleConnections()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return netip.Addr{}, E.New("HTTP ", response.StatusCode)
+	}
+	content, err := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	value, err := s.extract(content)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(value))
+	if err != nil {
+		return netip.Addr{}, E.Cause(err, "parse response")
+	}
+	return addr, nil
+}
+
+func (s *httpSource) extract(content []byte) (string, error) {
+	value := string(content)
+	if s.jsonField != nil {
+		var object any
+		err := json.Unmarshal(content, &object)
+		if err != nil {
+			return "", E.Cause(err, "parse json response")
+		}
+		for _, field := range s.jsonField {
+			fields, isObject := object.(map[string]any)
+			if !isObject {
+				return "", E.New("missing json field ", strings.Join(s.jsonField, "."))
+			}
+			object = fields[field]
+		}
+		var isString bool
+		value, isString = object.(string)
+		if !isString {
+			return "", E.New("missing json field ", strings.Join(s.jsonField, "."))
+		}
+	}
+	if s.pattern != nil {
+		match := s.pattern.FindStringSubmatch(value)
+		if match == nil {
+			return "", E.New("response does not match regexp")
+		}
+		value = match[0]
+		if len(match) > 1 {
+			value = match[1]
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,56 @@
+package publicip
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+const natPMPPort = "5351"
+
+// natPMPSource asks the gateway for its external address by NAT-PMP (RFC 6886).
+type natPMPSource struct {
+	server string
+}
+
+func newNATPMPSource(options SourceOptions) *natPMPSource {
+	if options.Server == "" {
+		return &natPMPSource{}
+	}
+	return &natPMPSource{withDefaultPort(options.Server, natPMPPort)}
+}
+
+func (s *natPMPSource) Name() string {
+	if s.server != "" {
+		return "nat-pmp " + s.server
+	}
+	return "nat-pmp"
+}
+
+func (s *natPMPSource) Lookup(ctx context.Context, network string) (netip.Addr, error) {
+	if network != NetworkIPv4 {
+		return netip.Addr{}, ErrUnsupported
+	}
+	server := s.server
+	if server == "" {
+		gateway, err := defaultGateway()
+		if err != nil {
+			return netip.Addr{}, E.Cause(err, "nat-pmp: find default gateway")
+		}
+		server = net.JoinHostPort(gateway.String(), natPMPPort)
+	}
+	// version 0, opcode 0: external address request
+	response, err := exchangeUDP(ctx, "udp4", server, []byte{0, 0}, func(response []byte) bool {
+		return len(response) >= 12 && response[0] == 0 && response[1] == 128
+	})
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if resultCode := binary.BigEndian.Uint16(response[2:]); resultCode != 0 {
+		return netip.Addr{}, E.New("nat-pmp: result code ", resultCode)
+	}
+	return netip.AddrFrom4([4]byte{response[8], response[9], response[10], response[11]}), nil
+}
@@ -0,0 +1,190 @@
+package publicip
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+const (
+	NetworkIPv4 = "ip4"
+	NetworkIPv6 = "ip6"
+)
+
+// cgnatPrefix is the shared address space of carrier-grade NAT (RFC 6598), which
+// netip.Addr.IsPrivate does not cover.
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// ErrUnsupported is returned by sources that can not discover addresses of the network.
+var ErrUnsupported = errors.New("unsupported network")
+
+type Options struct {
+	Sources []SourceOptions `json:"sources"`
+	// Quorum is the number of sources that must agree on the address, a majority of the
+	// answering sources by default.
+	Quorum int `json:"quorum"`
+	// Timeout of a lookup in seconds, 10 by default.
+	Timeout int64 `json:"timeout"`
+}
+
+type SourceOptions struct {
+	// Type is one of http, stun, dns, upnp and nat_pmp.
+	Type string `json:"type"`
+	// URL is the endpoint of http sources, or the root device description of upnp
+	// sources to skip SSDP discovery.
+	URL string `json:"url"`
+	// JSONField and Regexp extract the address from the http response, which is the
+	// trimmed body by default. Regexp uses its first group if it has one.
+	JSONField string `json:"json_field"`
+	Regexp    string `json:"regexp"`
+	// Server is the address of stun, dns and nat_pmp sources. nat_pmp sources use the
+	// default gateway by default.
+	Server string `json:"server"`
+	// Name and QueryType are the question of dns sources. The query type is TXT, or A
+	// and AAAA by network by default.
+	Name      string `json:"name"`
+	QueryType string `json:"query_type"`
+}
+
+type Source interface {
+	Name() string
+	Lookup(ctx context.Context, network string) (netip.Addr, error)
+}
+
+func NewSource(options SourceOptions) (Source, error) {
+	switch strings.ToLower(options.Type) {
+	case "http":
+		return newHTTPSource(options)
+	case "stun":
+		return newSTUNSource(options)
+	case "dns":
+		return newDNSSource(options)
+	case "upnp":
+		return newUPnPSource(options), nil
+	case "nat_pmp", "natpmp":
+		return newNATPMPSource(options), nil
+	default:
+		return nil, E.New("unknown source type: ", options.Type)
+	}
+}
+
+// Resolver asks all sources for the public address and returns the one agreed on.
+type Resolver struct {
+	sources []Source
+	quorum  int
+	timeout time.Duration
+}
+
+func NewResolver(options Options) (*Resolver, error) {
+	if len(options.Sources) == 0 {
+		return nil, E.New("missing sources")
+	}
+	if options.Quorum < 0 || options.Quorum > len(options.Sources) {
+		return nil, E.New("quorum must be between 1 and the number of sources")
+	}
+	resolver := &Resolver{
+		quorum:  options.Quorum,
+		timeout: 10 * time.Second,
+	}
+	if options.Timeout > 0 {
+		resolver.timeout = time.Duration(options.Timeout) * time.Second
+	}
+	for i, sourceOptions := range options.Sources {
+		source, err := NewSource(sourceOptions)
+		if err != nil {
+			return nil, E.Cause(err, "source[", i, "]")
+		}
+		resolver.sources = append(resolver.sources, source)
+	}
+	return resolver, nil
+}
+
+type lookupResult struct {
+	source Source
+	addr   netip.Addr
+	err    error
+}
+
+// Lookup returns the public address of the network, ip4 or ip6. Results that are not
+// public unicast addresses, e.g. of a router behind CGNAT, are discarded.
+func (r *Resolver) Lookup(ctx context.Context, network string) (netip.Addr, error) {
+	if network != NetworkIPv4 && network != NetworkIPv6 {
+		return netip.Addr{}, E.New("unknown network: ", network)
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	results := make([]lookupResult, len(r.sources))
+	var wg sync.WaitGroup
+	for i, source := range r.sources {
+		wg.Add(1)
+		go func(i int, source Source) {
+			defer wg.Done()
+			addr, err := source.Lookup(ctx, network)
+			if err == nil {
+				addr = addr.Unmap()
+				if !addr.IsGlobalUnicast() || addr.IsPrivate() || cgnatPrefix.Contains(addr) {
+					err = E.New("not a public address: ", addr)
+				} else if addr.Is4() != (network == NetworkIPv4) {
+					err = E.New("unexpected address: ", addr)
+				}
+			}
+			results[i] = lookupResult{source, addr, err}
+		}(i, source)
+	}
+	wg.Wait()
+	var (
+		answered int
+		failures []string
+	)
+	votes := make(map[netip.Addr]int)
+	for _, result := range results {
+		if result.err != nil {
+			if !errors.Is(result.err, ErrUnsupported) {
+				failures = append(failures, result.source.Name()+": "+result.err.Error())
+			}
+			continue
+		}
+		answered++
+		votes[result.addr]++
+	}
+	if answered == 0 {
+		if len(failures) == 0 {
+			return netip.Addr{}, ErrUnsupported
+		}
+		return netip.Addr{}, E.New("all sources failed: ", strings.Join(failures, "; "))
+	}
+	candidates := make([]netip.Addr, 0, len(votes))
+	for addr := range votes {
+		candidates = append(candidates, addr)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return votes[candidates[i]] > votes[candidates[j]]
+	})
+	best := candidates[0]
+	if len(candidates) > 1 && votes[candidates[1]] == votes[best] {
+		return netip.Addr{}, E.New("sources disagree: ", formatVotes(candidates, votes))
+	}
+	quorum := r.quorum
+	if quorum == 0 {
+		quorum = answered/2 + 1
+	}
+	if votes[best] < quorum {
+		return netip.Addr{}, E.New("no quorum of ", quorum, " sources: ", formatVotes(candidates, votes))
+	}
+	return best, nil
+}
+
+func formatVotes(candidates []netip.Addr, votes map[netip.Addr]int) string {
+	var parts []string
+	for _, addr := range candidates {
+		parts = append(parts, addr.String()+" x"+strconv.Itoa(votes[addr]))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,145 @@
+package publicip
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testSource struct {
+	name string
+	addr string
+	err  error
+}
+
+func (s testSource) Name() string {
+	return s.name
+}
+
+func (s testSource) Lookup(ctx context.Context, network string) (netip.Addr, error) {
+	if s.err != nil {
+		return netip.Addr{}, s.err
+	}
+	return netip.MustParseAddr(s.addr), nil
+}
+
+func lookupTestSources(quorum int, network string, sources ...testSource) (netip.Addr, error) {
+	resolver := &Resolver{quorum: quorum, timeout: time.Second}
+	for _, source := range sources {
+		resolver.sources = append(resolver.sources, source)
+	}
+	return resolver.Lookup(context.Background(), network)
+}
+
+func TestResolverConsensus(t *testing.T) {
+	for _, it := range []struct {
+		name     string
+		quorum   int
+		network  string
+		sources  []testSource
+		expected string
+		err      string
+	}{
+		{
+			name: "majority",
+			sources: []testSource{
+				{name: "a", addr: "203.0.113.7"},
+				{name: "b", addr: "203.0.113.7"},
+				{name: "c", addr: "198.51.100.1"},
+			},
+			expected: "203.0.113.7",
+		},
+		{
+			name: "failures do not count",
+			sources: []testSource{
+				{name: "a", addr: "203.0.113.7"},
+				{name: "b", err: errors.New("timeout")},
+				{name: "c", err: ErrUnsupported},
+			},
+			expected: "203.0.113.7",
+		},
+		{
+			name: "tie",
+			sources: []testSource{
+				{name: "a", addr: "203.0.113.7"},
+				{name: "b", addr: "198.51.100.1"},
+			},
+			err: "sources disagree",
+		},
+		{
+			name:   "no quorum",
+			quorum: 3,
+			sources: []testSource{
+				{name: "a", addr: "203.0.113.7"},
+				{name: "b", addr: "203.0.113.7"},
+				{name: "c", addr: "198.51.100.1"},
+			},
+			err: "no quorum",
+		},
+		{
+			name: "private and shared addresses are discarded",
+			sources: []testSource{
+				{name: "a", addr: "203.0.113.7"},
+				{name: "b", addr: "192.168.1.1"},
+				{name: "c", addr: "100.64.0.1"},
+				{name: "d", addr: "100.127.255.254"},
+				{name: "e", addr: "10.0.0.1"},
+			},
+			expected: "203.0.113.7",
+		},
+		{
+			name: "only shared address",
+			sources: []testSource{
+				{name: "a", addr: "100.100.1.1"},
+			},
+			err: "not a public address",
+		},
+		{
+			name: "mapped address",
+			sources: []testSource{
+				{name: "a", addr: "::ffff:203.0.113.7"},
+			},
+			expected: "203.0.113.7",
+		},
+		{
+			name:    "ipv6",
+			network: NetworkIPv6,
+			sources: []testSource{
+				{name: "a", addr: "2001:db8::1"},
+				{name: "b", addr: "203.0.113.7"},
+				{name: "c", addr: "fd00::1"},
+				{name: "d", addr: "2001:db8::1"},
+			},
+			expected: "2001:db8::1",
+		},
+	} {
+		network := it.network
+		if network == "" {
+			network = NetworkIPv4
+		}
+		addr, err := lookupTestSources(it.quorum, network, it.sources...)
+		if it.err != "" {
+			if err == nil || !strings.Contains(err.Error(), it.err) {
+				t.Error(it.name, ": expected error ", it.err, ", got ", addr, err)
+			}
+			continue
+		}
+		if err != nil || addr.String() != it.expected {
+			t.Error(it.name, ": expected ", it.expected, ", got ", addr, err)
+		}
+	}
+}
+
+func TestResolverUnsupported(t *testing.T) {
+	_, err := lookupTestSources(0, NetworkIPv6, testSource{name: "a", err: ErrUnsupported})
+	if err != ErrUnsupported {
+		t.Fatal("expected ErrUnsupported, got ", err)
+	}
+	_, err = lookupTestSources(0, NetworkIPv4, testSource{name: "a", err: errors.New("refused")})
+	if err == nil || !strings.Contains(err.Error(), "all sources failed") {
+		t.Fatal("expected all sources to fail, got ", err)
+	}
+}
@@ -0,0 +1,243 @@
+package publicip
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// serveUDP answers every packet on a local UDP socket with the reply of handler.
+func serveUDP(t *testing.T, handler func(request []byte) []byte) string {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	go func() {
+		buffer := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			if response := handler(buffer[:n]); response != nil {
+				conn.WriteTo(response, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func lookupSource(t *testing.T, options SourceOptions, network string) (netip.Addr, error) {
+	t.Helper()
+	source, err := NewSource(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return source.Lookup(ctx, network)
+}
+
+func TestHTTPSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/plain":
+			w.Write([]byte("203.0.113.7\n"))
+		case "/json":
+			w.Write([]byte(`{"client":{"ip":"203.0.113.8"}}`))
+		case "/html":
+			w.Write([]byte("<p>Current IP Address: 203.0.113.9</p>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	for _, it := range []struct {
+		options  SourceOptions
+		expected string
+	}{
+		{SourceOptions{URL: server.URL + "/plain"}, "203.0.113.7"},
+		{SourceOptions{URL: server.URL + "/json", JSONField: "client.ip"}, "203.0.113.8"},
+		{SourceOptions{URL: server.URL + "/html", Regexp: `Address: ([0-9.]+)`}, "203.0.113.9"},
+	} {
+		it.options.Type = "http"
+		addr, err := lookupSource(t, it.options, NetworkIPv4)
+		if err != nil || addr.String() != it.expected {
+			t.Error(it.options.URL, ": expected ", it.expected, ", got ", addr, err)
+		}
+	}
+	for _, options := range []SourceOptions{
+		{URL: server.URL + "/missing"},
+		{URL: server.URL + "/json", JSONField: "client.address"},
+		{URL: server.URL + "/plain", Regexp: `Address: ([0-9.]+)`},
+	} {
+		options.Type = "http"
+		_, err := lookupSource(t, options, NetworkIPv4)
+		if err == nil {
+			t.Error(options.URL, ": expected error")
+		}
+	}
+}
+
+func stunResponse(request []byte, attributeType uint16, addr netip.Addr) []byte {
+	value := []byte{0, stunAddressFamilyIPv4, 0x12, 0x34}
+	address := addr.AsSlice()
+	if attributeType == stunXORMappedAddress {
+		for i := range address {
+			address[i] ^= request[4+i]
+		}
+	}
+	value = append(value, address...)
+	response := make([]byte, stunHeaderLength, stunHeaderLength+stunAttributeHeaderLength+len(value))
+	binary.BigEndian.PutUint16(response[0:], stunBindingSuccess)
+	binary.BigEndian.PutUint16(response[2:], uint16(stunAttributeHeaderLength+len(value)))
+	copy(response[4:], request[4:20])
+	response = binary.BigEndian.AppendUint16(response, attributeType)
+	response = binary.BigEndian.AppendUint16(response, uint16(len(value)))
+	return append(response, value...)
+}
+
+func TestSTUNSource(t *testing.T) {
+	for attributeType, expected := range map[uint16]string{
+		stunXORMappedAddress: "203.0.113.7",
+		stunMappedAddress:    "203.0.113.8",
+	} {
+		attributeType, expected := attributeType, expected
+		server := serveUDP(t, func(request []byte) []byte {
+			if len(request) != stunHeaderLength || binary.BigEndian.Uint16(request) != stunBindingRequest {
+				return nil
+			}
+			return stunResponse(request, attributeType, netip.MustParseAddr(expected))
+		})
+		addr, err := lookupSource(t, SourceOptions{Type: "stun", Server: server}, NetworkIPv4)
+		if err != nil || addr.String() != expected {
+			t.Error("expected ", expected, ", got ", addr, err)
+		}
+	}
+}
+
+func TestDNSSource(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, request *dns.Msg) {
+		response := new(dns.Msg)
+		response.SetReply(request)
+		question := request.Question[0]
+		header := dns.RR_Header{Name: question.Name, Rrtype: question.Qtype, Class: dns.ClassINET, Ttl: 0}
+		switch {
+		case question.Name == "myip.example." && question.Qtype == dns.TypeA:
+			response.Answer = append(response.Answer, &dns.A{Hdr: header, A: net.ParseIP("203.0.113.7")})
+		case question.Name == "myaddr.example." && question.Qtype == dns.TypeTXT:
+			response.Answer = append(response.Answer, &dns.TXT{Hdr: header, Txt: []string{"2001:db8::1", "203.0.113.8"}})
+		default:
+			response.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(response)
+	})}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+	address := conn.LocalAddr().String()
+
+	addr, err := lookupSource(t, SourceOptions{Type: "dns", Server: address, Name: "myip.example"}, NetworkIPv4)
+	if err != nil || addr.String() != "203.0.113.7" {
+		t.Error("expected 203.0.113.7, got ", addr, err)
+	}
+	addr, err = lookupSource(t, SourceOptions{Type: "dns", Server: address, Name: "myaddr.example", QueryType: "txt"}, NetworkIPv4)
+	if err != nil || addr.String() != "203.0.113.8" {
+		t.Error("expected 203.0.113.8, got ", addr, err)
+	}
+	_, err = lookupSource(t, SourceOptions{Type: "dns", Server: address, Name: "missing.example"}, NetworkIPv4)
+	if err == nil {
+		t.Error("expected NXDOMAIN to fail")
+	}
+}
+
+func TestUPnPSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rootDesc.xml":
+			w.Write([]byte(`<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+<device>
+<deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+<serviceList><service>
+<serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+<controlURL>/ctl/IPConn</controlURL>
+</service></serviceList>
+</device></deviceList>
+</device></deviceList>
+</device>
+</root>`))
+		case "/ctl/IPConn":
+			content, _ := io.ReadAll(r.Body)
+			if r.Method != http.MethodPost ||
+				r.Header.Get("SOAPAction") != `"urn:schemas-upnp-org:service:WANIPConnection:1#GetExternalIPAddress"` ||
+				!strings.Contains(string(content), "GetExternalIPAddress") {
+				http.Error(w, "bad request", http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte(`<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
+<NewExternalIPAddress>203.0.113.7</NewExternalIPAddress>
+</u:GetExternalIPAddressResponse>
+</s:Body></s:Envelope>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	options := SourceOptions{Type: "upnp", URL: server.URL + "/rootDesc.xml"}
+	addr, err := lookupSource(t, options, NetworkIPv4)
+	if err != nil || addr.String() != "203.0.113.7" {
+		t.Fatal("expected 203.0.113.7, got ", addr, err)
+	}
+	_, err = lookupSource(t, options, NetworkIPv6)
+	if err != ErrUnsupported {
+		t.Fatal("expected ErrUnsupported, got ", err)
+	}
+}
+
+func TestNATPMPSource(t *testing.T) {
+	server := serveUDP(t, func(request []byte) []byte {
+		if len(request) != 2 || request[0] != 0 || request[1] != 0 {
+			return nil
+		}
+		return []byte{0, 128, 0, 0, 0, 0, 0, 1, 203, 0, 113, 7}
+	})
+	options := SourceOptions{Type: "nat_pmp", Server: server}
+	addr, err := lookupSource(t, options, NetworkIPv4)
+	if err != nil || addr.String() != "203.0.113.7" {
+		t.Fatal("expected 203.0.113.7, got ", addr, err)
+	}
+	_, err = lookupSource(t, options, NetworkIPv6)
+	if err != ErrUnsupported {
+		t.Fatal("expected ErrUnsupported, got ", err)
+	}
+
+	failing := serveUDP(t, func(request []byte) []byte {
+		return []byte{0, 128, 0, 3, 0, 0, 0, 1, 0, 0, 0, 0}
+	})
+	_, err = lookupSource(t, SourceOptions{Type: "nat_pmp", Server: failing}, NetworkIPv4)
+	if err == nil {
+		t.Fatal("expected result code to fail")
+	}
+}
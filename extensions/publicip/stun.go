@@ -0,0 +1,146 @@
+package publicip
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"net/netip"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+const (
+	stunMagicCookie           = 0x2112A442
+	stunBindingRequest        = 0x0001
+	stunBindingSuccess        = 0x0101
+	stunHeaderLength          = 20
+	stunMappedAddress         = 0x0001
+	stunXORMappedAddress      = 0x0020
+	stunXORMappedAddressOld   = 0x8020
+	stunAddressFamilyIPv4     = 0x01
+	stunAddressFamilyIPv6     = 0x02
+	stunDefaultPort           = "3478"
+	stunAttributeHeaderLength = 4
+)
+
+// stunSource sends a STUN binding request (RFC 5389) and reads the mapped address.
+type stunSource struct {
+	server string
+}
+
+func newSTUNSource(options SourceOptions) (*stunSource, error) {
+	if options.Server == "" {
+		return nil, E.New("stun: missing server")
+	}
+	return &stunSource{withDefaultPort(options.Server, stunDefaultPort)}, nil
+}
+
+func withDefaultPort(server string, port string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(server, port)
+}
+
+func (s *stunSource) Name() string {
+	return "stun " + s.server
+}
+
+func (s *stunSource) Lookup(ctx context.Context, network string) (netip.Addr, error) {
+	dialNetwork := "udp4"
+	if network == NetworkIPv6 {
+		dialNetwork = "udp6"
+	}
+	request := make([]byte, stunHeaderLength)
+	binary.BigEndian.PutUint16(request[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(request[4:], stunMagicCookie)
+	_, err := rand.Read(request[8:20])
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	response, err := exchangeUDP(ctx, dialNetwork, s.server, request, func(response []byte) bool {
+		return len(response) >= stunHeaderLength && bytes.Equal(response[4:20], request[4:20])
+	})
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return parseSTUNResponse(response)
+}
+
+func parseSTUNResponse(response []byte) (netip.Addr, error) {
+	if binary.BigEndian.Uint16(response[0:]) != stunBindingSuccess {
+		return netip.Addr{}, E.New("stun: binding failed")
+	}
+	length := int(binary.BigEndian.Uint16(response[2:]))
+	if stunHeaderLength+length > len(response) {
+		return netip.Addr{}, E.New("stun: truncated response")
+	}
+	transactionID := response[4:20]
+	attributes := response[stunHeaderLength : stunHeaderLength+length]
+	var mapped netip.Addr
+	for len(attributes) >= stunAttributeHeaderLength {
+		attributeType := binary.BigEndian.Uint16(attributes[0:])
+		attributeLength := int(binary.BigEndian.Uint16(attributes[2:]))
+		if stunAttributeHeaderLength+attributeLength > len(attributes) {
+			return netip.Addr{}, E.New("stun: truncated attribute")
+		}
+		value := attributes[stunAttributeHeaderLength : stunAttributeHeaderLength+attributeLength]
+		switch attributeType {
+		case stunXORMappedAddress, stunXORMappedAddressOld:
+			addr, err := parseSTUNAddress(value, transactionID)
+			if err != nil {
+				return netip.Addr{}, err
+			}
+			return addr, nil
+		case stunMappedAddress:
+			addr, err := parseSTUNAddress(value, nil)
+			if err != nil {
+				return netip.Addr{}, err
+			}
+			mapped = addr
+		}
+		// attributes are padded to a multiple of four bytes
+		next := stunAttributeHeaderLength + (attributeLength+3)&^3
+		if next > len(attributes) {
+			break
+		}
+		attributes = attributes[next:]
+	}
+	if !mapped.IsValid() {
+		return netip.Addr{}, E.New("stun: missing mapped address")
+	}
+	return mapped, nil
+}
+
+// parseSTUNAddress parses a MAPPED-ADDRESS value, or a XOR-MAPPED-ADDRESS value if the
+// transaction ID is given.
+func parseSTUNAddress(value []byte, transactionID []byte) (netip.Addr, error) {
+	if len(value) < 4 {
+		return netip.Addr{}, E.New("stun: bad address attribute")
+	}
+	var address []byte
+	switch value[1] {
+	case stunAddressFamilyIPv4:
+		address = make([]byte, 4)
+	case stunAddressFamilyIPv6:
+		address = make([]byte, 16)
+	default:
+		return netip.Addr{}, E.New("stun: unknown address family ", value[1])
+	}
+	if len(value) < 4+len(address) {
+		return netip.Addr{}, E.New("stun: bad address attribute")
+	}
+	copy(address, value[4:])
+	if transactionID != nil {
+		mask := make([]byte, 16)
+		binary.BigEndian.PutUint32(mask, stunMagicCookie)
+		copy(mask[4:], transactionID)
+		for i := range address {
+			address[i] ^= mask[i]
+		}
+	}
+	addr, _ := netip.AddrFromSlice(address)
+	return addr, nil
+}
@@ -0,0 +1,49 @@
+package publicip
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// exchangeUDP sends request to server until a response accepted by check arrives,
+// doubling the retransmission interval from 250ms, or ctx is done.
+func exchangeUDP(ctx context.Context, network, server string, request []byte, check func(response []byte) bool) ([]byte, error) {
+	dialer := new(net.Dialer)
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	buffer := make([]byte, 1500)
+	interval := 250 * time.Millisecond
+	for {
+		_, err = conn.Write(request)
+		if err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(interval)
+		if ctxDeadline, hasDeadline := ctx.Deadline(); hasDeadline && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		conn.SetReadDeadline(deadline)
+		for {
+			n, err := conn.Read(buffer)
+			if err != nil {
+				if netErr, isNetErr := err.(net.Error); !isNetErr || !netErr.Timeout() {
+					return nil, err
+				}
+				break
+			}
+			if check(buffer[:n]) {
+				return buffer[:n], nil
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if interval < 4*time.Second {
+			interval *= 2
+		}
+	}
+}
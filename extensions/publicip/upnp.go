@@ -0,0 +1,196 @@
+package publicip
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+const (
+	ssdpAddress   = "239.255.255.250:1900"
+	upnpIGDDevice = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+)
+
+// upnpSource asks the Internet Gateway Device of the network for its external address.
+type upnpSource struct {
+	location string
+}
+
+func newUPnPSource(options SourceOptions) *upnpSource {
+	return &upnpSource{options.URL}
+}
+
+func (s *upnpSource) Name() string {
+	if s.location != "" {
+		return "upnp " + s.location
+	}
+	return "upnp"
+}
+
+func (s *upnpSource) Lookup(ctx context.Context, network string) (netip.Addr, error) {
+	if network != NetworkIPv4 {
+		return netip.Addr{}, ErrUnsupported
+	}
+	location := s.location
+	if location == "" {
+		var err error
+		location, err = discoverIGD(ctx)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+	}
+	serviceType, controlURL, err := findWANService(ctx, location)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return getExternalIPAddress(ctx, serviceType, controlURL)
+}
+
+// discoverIGD sends a SSDP search and returns the location of the first gateway answering.
+func discoverIGD(ctx context.Context) (string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	destination, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		return "", err
+	}
+	request := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddress + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: " + upnpIGDDevice + "\r\n\r\n"
+	_, err = conn.WriteTo([]byte(request), destination)
+	if err != nil {
+		return "", err
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	if ctxDeadline, hasDeadline := ctx.Deadline(); hasDeadline && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetReadDeadline(deadline)
+	buffer := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return "", E.Cause(err, "upnp: no gateway found")
+		}
+		response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buffer[:n])), nil)
+		if err != nil || response.StatusCode != http.StatusOK {
+			continue
+		}
+		location := response.Header.Get("Location")
+		if location != "" {
+			return location, nil
+		}
+	}
+}
+
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+type upnpRoot struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+func (d *upnpDevice) findService() (serviceType string, controlURL string) {
+	for _, service := range d.Services {
+		if strings.Contains(service.ServiceType, ":WANIPConnection:") || strings.Contains(service.ServiceType, ":WANPPPConnection:") {
+			return service.ServiceType, service.ControlURL
+		}
+	}
+	for _, device := range d.Devices {
+		serviceType, controlURL = device.findService()
+		if serviceType != "" {
+			return
+		}
+	}
+	return
+}
+
+func findWANService(ctx context.Context, location string) (string, string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return "", "", err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", "", E.New("upnp: HTTP ", response.StatusCode)
+	}
+	var root upnpRoot
+	err = xml.NewDecoder(io.LimitReader(response.Body, 1024*1024)).Decode(&root)
+	if err != nil {
+		return "", "", E.Cause(err, "upnp: parse device description")
+	}
+	serviceType, controlURL := root.Device.findService()
+	if serviceType == "" {
+		return "", "", E.New("upnp: no WAN connection service")
+	}
+	base := location
+	if root.URLBase != "" {
+		base = root.URLBase
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", "", err
+	}
+	control, err := baseURL.Parse(controlURL)
+	if err != nil {
+		return "", "", err
+	}
+	return serviceType, control.String(), nil
+}
+
+func getExternalIPAddress(ctx context.Context, serviceType string, controlURL string) (netip.Addr, error) {
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:GetExternalIPAddress xmlns:u="` + serviceType + `"/></s:Body></s:Envelope>`
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, controlURL, strings.NewReader(body))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	request.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	request.Header.Set("SOAPAction", `"`+serviceType+`#GetExternalIPAddress"`)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return netip.Addr{}, E.New("upnp: HTTP ", response.StatusCode)
+	}
+	var envelope struct {
+		Address string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	err = xml.NewDecoder(io.LimitReader(response.Body, 64*1024)).Decode(&envelope)
+	if err != nil {
+		return netip.Addr{}, E.Cause(err, "upnp: parse response")
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(envelope.Address))
+	if err != nil {
+		return netip.Addr{}, E.Cause(err, "upnp: parse external address")
+	}
+	return addr, nil
+}
@@ -0,0 +1,457 @@
+package selfsign
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"io/fs"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+const (
+	CACertificateFile = "ca.crt"
+	CAKeyFile         = "ca.key"
+
+	defaultCAValidityDays   = 3650
+	defaultLeafValidityDays = 365
+	// certificates are valid from slightly in the past to tolerate clock skew
+	backdate = time.Hour
+)
+
+type SubjectOptions struct {
+	CommonName         string   `json:"common_name"`
+	Organization       []string `json:"organization"`
+	OrganizationalUnit []string `json:"organizational_unit"`
+	Country            []string `json:"country"`
+	Province           []string `json:"province"`
+	Locality           []string `json:"locality"`
+}
+
+func (o SubjectOptions) name() pkix.Name {
+	return pkix.Name{
+		CommonName:         o.CommonName,
+		Organization:       o.Organization,
+		OrganizationalUnit: o.OrganizationalUnit,
+		Country:            o.Country,
+		Province:           o.Province,
+		Locality:           o.Locality,
+	}
+}
+
+type CAOptions struct {
+	// Subject of the CA, common name "Self-Signed Root CA" by default.
+	Subject SubjectOptions `json:"subject"`
+	// KeyType is one of ec256 (default), ec384, ed25519, rsa2048 and rsa4096.
+	KeyType string `json:"key_type"`
+	// ValidityDays is 3650 by default.
+	ValidityDays int `json:"validity_days"`
+}
+
+type CertificateOptions struct {
+	Subject SubjectOptions `json:"subject"`
+	// Hosts are DNS names or IP addresses, the first one is the common name if not set.
+	Hosts          []string `json:"hosts"`
+	EmailAddresses []string `json:"email_addresses"`
+	URIs           []string `json:"uris"`
+	// KeyType is one of ec256 (default), ec384, ed25519, rsa2048 and rsa4096.
+	KeyType string `json:"key_type"`
+	// ValidityDays is 365 by default.
+	ValidityDays int `json:"validity_days"`
+	// ClientAuth additionally allows the certificate for TLS client authentication.
+	ClientAuth bool `json:"client_auth"`
+}
+
+func (o CertificateOptions) subject() pkix.Name {
+	subject := o.Subject.name()
+	if subject.CommonName == "" && len(o.Hosts) > 0 {
+		subject.CommonName = o.Hosts[0]
+	}
+	return subject
+}
+
+// CA is a root certificate authority issuing leaf certificates.
+type CA struct {
+	Certificate *x509.Certificate
+	PrivateKey  crypto.Signer
+}
+
+func randomSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func validity(days int, defaultDays int) (time.Time, time.Time) {
+	if days <= 0 {
+		days = defaultDays
+	}
+	notBefore := time.Now().Add(-backdate).Truncate(time.Second)
+	return notBefore, notBefore.AddDate(0, 0, days)
+}
+
+// NewCA creates a root CA with a new key.
+func NewCA(options CAOptions) (*CA, error) {
+	keyType, err := ParseKeyType(options.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := generateKey(keyType)
+	if err != nil {
+		return nil, err
+	}
+	return newCA(options, privateKey)
+}
+
+func newCA(options CAOptions, privateKey crypto.Signer) (*CA, error) {
+	serialNumber, err := randomSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	subject := options.Subject.name()
+	if subject.CommonName == "" {
+		subject.CommonName = "Self-Signed Root CA"
+	}
+	notBefore, notAfter := validity(options.ValidityDays, defaultCAValidityDays)
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               subject,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	content, err := x509.CreateCertificate(rand.Reader, template, template, privateKey.Public(), privateKey)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := x509.ParseCertificate(content)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{certificate, privateKey}, nil
+}
+
+// LoadCA loads a CA from PEM encoded certificate and key files.
+func LoadCA(certificatePath string, keyPath string) (*CA, error) {
+	certificateContent, err := os.ReadFile(certificatePath)
+	if err != nil {
+		return nil, err
+	}
+	keyContent, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := parseCertificate(certificateContent)
+	if err != nil {
+		return nil, E.Cause(err, "parse ", certificatePath)
+	}
+	privateKey, err := parsePrivateKey(keyContent)
+	if err != nil {
+		return nil, E.Cause(err, "parse ", keyPath)
+	}
+	if !certificate.IsCA {
+		return nil, E.New(certificatePath, " is not a CA certificate")
+	}
+	if !publicKeyEqual(certificate.PublicKey, privateKey.Public()) {
+		return nil, E.New(keyPath, " does not match ", certificatePath)
+	}
+	return &CA{certificate, privateKey}, nil
+}
+
+// LoadOrCreateCA loads the CA persisted in directory, or creates and persists a new one.
+// A key saved without its certificate, e.g. after a crash, is reused for the new one.
+func LoadOrCreateCA(directory string, options CAOptions) (*CA, error) {
+	certificatePath := filepath.Join(directory, CACertificateFile)
+	keyPath := filepath.Join(directory, CAKeyFile)
+	if _, err := os.Stat(certificatePath); err == nil {
+		err = restrictKeyFile(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		return LoadCA(certificatePath, keyPath)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	var privateKey crypto.Signer
+	keyContent, err := os.ReadFile(keyPath)
+	if err == nil {
+		privateKey, err = parsePrivateKey(keyContent)
+		if err != nil {
+			return nil, E.Cause(err, "parse ", keyPath)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	var ca *CA
+	if privateKey != nil {
+		ca, err = newCA(options, privateKey)
+	} else {
+		ca, err = NewCA(options)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = ca.Save(certificatePath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+// Save writes the certificate and the key of the CA, the key readable by the owner only.
+// The key is written first, so that a certificate is never left without its key.
+func (c *CA) Save(certificatePath string, keyPath string) error {
+	keyContent, err := encodePrivateKey(c.PrivateKey)
+	if err != nil {
+		return err
+	}
+	err = writeFile(keyPath, keyContent, 0o600)
+	if err != nil {
+		return err
+	}
+	return writeFile(certificatePath, c.CertificatePEM(), 0o644)
+}
+
+// CertificatePEM exports the CA certificate for clients to trust.
+func (c *CA) CertificatePEM() []byte {
+	return encodeCertificate(c.Certificate)
+}
+
+// CertPool returns a pool containing only the CA certificate.
+func (c *CA) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.Certificate)
+	return pool
+}
+
+// SPKIPin returns the base64 encoded SHA-256 digest of the public key of the CA, for
+// clients pinning the key rather than the certificate.
+func (c *CA) SPKIPin() string {
+	digest := sha256.Sum256(c.Certificate.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// Issue creates a leaf certificate with a new key signed by the CA.
+func (c *CA) Issue(options CertificateOptions) (*tls.Certificate, error) {
+	keyType, err := ParseKeyType(options.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := generateKey(keyType)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := randomSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	notBefore, notAfter := validity(options.ValidityDays, defaultLeafValidityDays)
+	if notAfter.After(c.Certificate.NotAfter) {
+		notAfter = c.Certificate.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               options.subject(),
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		EmailAddresses:        options.EmailAddresses,
+	}
+	if _, isRSA := privateKey.(*rsa.PrivateKey); isRSA {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	if options.ClientAuth {
+		template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+	}
+	for _, host := range options.Hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	for _, it := range options.URIs {
+		uri, err := url.Parse(it)
+		if err != nil {
+			return nil, E.Cause(err, "parse uri ", it)
+		}
+		template.URIs = append(template.URIs, uri)
+	}
+	content, err := x509.CreateCertificate(rand.Reader, template, c.Certificate, privateKey.Public(), c.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(content)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{content},
+		PrivateKey:  privateKey,
+		Leaf:        leaf,
+	}, nil
+}
+
+// LoadOrIssue loads the certificate persisted at the paths, or issues and persists a new
+// one if it is missing, not signed by the CA, differs from options, or has less than a
+// third of its lifetime left.
+func (c *CA) LoadOrIssue(certificatePath string, keyPath string, options CertificateOptions) (*tls.Certificate, error) {
+	certificate, err := tls.LoadX509KeyPair(certificatePath, keyPath)
+	if err == nil {
+		certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+		if err == nil && c.valid(certificate.Leaf, options) {
+			err = restrictKeyFile(keyPath)
+			if err != nil {
+				return nil, err
+			}
+			return &certificate, nil
+		}
+	} else if pathErr := (*fs.PathError)(nil); errors.As(err, &pathErr) && !os.IsNotExist(err) {
+		return nil, err
+	}
+	// files that do not parse or match, e.g. after a crash while saving, are replaced
+	issued, err := c.Issue(options)
+	if err != nil {
+		return nil, err
+	}
+	keyContent, err := encodePrivateKey(issued.PrivateKey.(crypto.Signer))
+	if err != nil {
+		return nil, err
+	}
+	err = writeFile(keyPath, keyContent, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	err = writeFile(certificatePath, encodeCertificate(issued.Leaf), 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return issued, nil
+}
+
+func (c *CA) valid(leaf *x509.Certificate, options CertificateOptions) bool {
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:     c.CertPool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return false
+	}
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	if time.Until(leaf.NotAfter) < lifetime/3 {
+		return false
+	}
+	keyType, err := ParseKeyType(options.KeyType)
+	if err != nil || keyTypeOf(leaf.PublicKey) != keyType {
+		return false
+	}
+	if leaf.Subject.String() != options.subject().String() {
+		return false
+	}
+	var clientAuth bool
+	for _, usage := range leaf.ExtKeyUsage {
+		clientAuth = clientAuth || usage == x509.ExtKeyUsageClientAuth
+	}
+	if clientAuth != options.ClientAuth {
+		return false
+	}
+	hosts := append([]string{}, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		hosts = append(hosts, ip.String())
+	}
+	expected := make([]string, 0, len(options.Hosts))
+	for _, host := range options.Hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			host = ip.String()
+		}
+		expected = append(expected, host)
+	}
+	var uris []string
+	for _, uri := range leaf.URIs {
+		uris = append(uris, uri.String())
+	}
+	var expectedURIs []string
+	for _, it := range options.URIs {
+		uri, err := url.Parse(it)
+		if err != nil {
+			return false
+		}
+		expectedURIs = append(expectedURIs, uri.String())
+	}
+	return sameStrings(hosts, expected) &&
+		sameStrings(leaf.EmailAddresses, options.EmailAddresses) &&
+		sameStrings(uris, expectedURIs)
+}
+
+func sameStrings(a []string, b []string) bool {
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return strings.Join(a, "\n") == strings.Join(b, "\n")
+}
+
+func publicKeyEqual(a crypto.PublicKey, b crypto.PublicKey) bool {
+	key, comparable := a.(interface{ Equal(crypto.PublicKey) bool })
+	return comparable && key.Equal(b)
+}
+
+// writeFile replaces path atomically, so that a crash never leaves a partial file.
+func writeFile(path string, content []byte, perm os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	// the temporary file is created with mode 0600
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(content)
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = file.Chmod(perm)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// restrictKeyFile makes an existing key file readable by the owner only.
+func restrictKeyFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if info.Mode().Perm()&0o077 == 0 {
+		return nil
+	}
+	return os.Chmod(path, info.Mode().Perm()&0o700)
+}
@@ -0,0 +1,135 @@
+package selfsign
+
+import (
+	"crypto"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadOrIssueReissuesOnChange(t *testing.T) {
+	ca, err := NewCA(CAOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	directory := t.TempDir()
+	certificatePath := filepath.Join(directory, "server.crt")
+	keyPath := filepath.Join(directory, "server.key")
+	options := CertificateOptions{
+		Subject:        SubjectOptions{Organization: []string{"Example"}},
+		Hosts:          []string{"example.com", "127.0.0.1"},
+		EmailAddresses: []string{"admin@example.com"},
+		URIs:           []string{"spiffe://example.com/server"},
+	}
+	issued, err := ca.LoadOrIssue(certificatePath, keyPath, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ca.LoadOrIssue(certificatePath, keyPath, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Leaf.Equal(issued.Leaf) {
+		t.Fatal("expected the persisted certificate to be loaded")
+	}
+	for name, changed := range map[string]func(options *CertificateOptions){
+		"key type":    func(options *CertificateOptions) { options.KeyType = "ed25519" },
+		"subject":     func(options *CertificateOptions) { options.Subject.Organization = []string{"Other"} },
+		"common name": func(options *CertificateOptions) { options.Subject.CommonName = "other" },
+		"client auth": func(options *CertificateOptions) { options.ClientAuth = true },
+		"email":       func(options *CertificateOptions) { options.EmailAddresses = nil },
+		"uri":         func(options *CertificateOptions) { options.URIs = []string{"spiffe://example.com/client"} },
+		"hosts":       func(options *CertificateOptions) { options.Hosts = options.Hosts[:1] },
+	} {
+		changedOptions := options
+		changed(&changedOptions)
+		if ca.valid(issued.Leaf, changedOptions) {
+			t.Error(name, ": expected changed options to invalidate the certificate")
+		}
+	}
+}
+
+func TestLoadOrIssueReplacesMismatchedFiles(t *testing.T) {
+	ca, err := NewCA(CAOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	directory := t.TempDir()
+	certificatePath := filepath.Join(directory, "server.crt")
+	keyPath := filepath.Join(directory, "server.key")
+	options := CertificateOptions{Hosts: []string{"example.com"}}
+	_, err = ca.LoadOrIssue(certificatePath, keyPath, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a crash after writing the key of a new certificate leaves it with the old certificate
+	other, err := ca.Issue(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyContent, _ := encodePrivateKey(other.PrivateKey.(crypto.Signer))
+	os.WriteFile(keyPath, keyContent, 0o600)
+	_, err = ca.LoadOrIssue(certificatePath, keyPath, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadOrCreateCAKeepsOrphanedKey(t *testing.T) {
+	directory := t.TempDir()
+	ca, err := LoadOrCreateCA(directory, CAOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a crash between writing the key and the certificate leaves the key only
+	err = os.Remove(filepath.Join(directory, CACertificateFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recreated, err := LoadOrCreateCA(directory, CAOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recreated.SPKIPin() != ca.SPKIPin() {
+		t.Fatal("expected the orphaned key to be reused")
+	}
+	loaded, err := LoadOrCreateCA(directory, CAOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Certificate.Equal(recreated.Certificate) {
+		t.Fatal("expected the persisted CA to be loaded")
+	}
+}
+
+func TestKeyFilePermissions(t *testing.T) {
+	directory := t.TempDir()
+	_, err := LoadOrCreateCA(directory, CAOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(directory, CAKeyFile)
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatal("unexpected key file mode ", info.Mode())
+	}
+	err = os.Chmod(keyPath, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadOrCreateCA(directory, CAOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ = os.Stat(keyPath)
+	if info.Mode().Perm() != 0o600 {
+		t.Fatal("expected existing key file to be restricted, got ", info.Mode())
+	}
+	entries, _ := os.ReadDir(directory)
+	if len(entries) != 2 {
+		t.Fatal("unexpected files left in ", directory, ": ", len(entries))
+	}
+}
@@ -0,0 +1,131 @@
+package selfsign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+type KeyType string
+
+const (
+	KeyTypeEC256   KeyType = "ec256"
+	KeyTypeEC384   KeyType = "ec384"
+	KeyTypeEd25519 KeyType = "ed25519"
+	KeyTypeRSA2048 KeyType = "rsa2048"
+	KeyTypeRSA4096 KeyType = "rsa4096"
+)
+
+// ParseKeyType parses one of ec256 (default, or ecdsa), ec384, ed25519, rsa2048 and rsa4096.
+func ParseKeyType(keyType string) (KeyType, error) {
+	switch strings.ToLower(keyType) {
+	case "", "ecdsa", "ec256", "p256":
+		return KeyTypeEC256, nil
+	case "ec384", "p384":
+		return KeyTypeEC384, nil
+	case "ed25519":
+		return KeyTypeEd25519, nil
+	case "rsa", "rsa2048":
+		return KeyTypeRSA2048, nil
+	case "rsa4096":
+		return KeyTypeRSA4096, nil
+	default:
+		return "", E.New("unknown key type: ", keyType)
+	}
+}
+
+func generateKey(keyType KeyType) (crypto.Signer, error) {
+	switch keyType {
+	case KeyTypeEC256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeEC384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeEd25519:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
+	case KeyTypeRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyTypeRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	default:
+		return nil, E.New("unknown key type: ", keyType)
+	}
+}
+
+// keyTypeOf returns the key type of publicKey, empty if it is none of the supported ones.
+func keyTypeOf(publicKey crypto.PublicKey) KeyType {
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return KeyTypeEC256
+		case elliptic.P384():
+			return KeyTypeEC384
+		}
+	case ed25519.PublicKey:
+		return KeyTypeEd25519
+	case *rsa.PublicKey:
+		switch key.N.BitLen() {
+		case 2048:
+			return KeyTypeRSA2048
+		case 4096:
+			return KeyTypeRSA4096
+		}
+	}
+	return ""
+}
+
+func encodePrivateKey(privateKey crypto.Signer) ([]byte, error) {
+	content, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: content}), nil
+}
+
+func parsePrivateKey(content []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, E.New("invalid private key")
+	}
+	var (
+		privateKey any
+		err        error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, isSigner := privateKey.(crypto.Signer)
+	if !isSigner {
+		return nil, E.New("unsupported private key")
+	}
+	return signer, nil
+}
+
+func parseCertificate(content []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, E.New("invalid certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func encodeCertificate(certificate *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
+}